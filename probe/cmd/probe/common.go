@@ -2,46 +2,78 @@ package main
 
 import (
 	"context"
+	"strconv"
 
+	"github.com/isac322/buildkit-state/probe/internal/ci"
 	"github.com/isac322/buildkit-state/probe/internal/remote"
+	azblobmanager "github.com/isac322/buildkit-state/probe/internal/remote/azblob"
+	gcsmanager "github.com/isac322/buildkit-state/probe/internal/remote/gcs"
 	"github.com/isac322/buildkit-state/probe/internal/remote/github"
+	localmanager "github.com/isac322/buildkit-state/probe/internal/remote/local"
+	ocimanager "github.com/isac322/buildkit-state/probe/internal/remote/oci"
 	"github.com/isac322/buildkit-state/probe/internal/remote/s3"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/docker/go-units"
 	"github.com/pkg/errors"
-	"github.com/sethvargo/go-githubactions"
+	"google.golang.org/api/option"
 )
 
 const (
-	inputBuildxName   = "buildx-name"
-	inputRemoteType   = "remote-type"
-	inputS3BucketName = "s3-bucket-name"
-	inputS3KeyPrefix  = "s3-key-prefix"
-	inputS3URL        = "s3-url"
+	inputBuildxName    = "buildx-name"
+	inputDriver        = "driver"
+	inputRemoteType    = "remote-type"
+	inputS3BucketName  = "s3-bucket-name"
+	inputS3KeyPrefix   = "s3-key-prefix"
+	inputS3URL         = "s3-url"
+	inputS3PartSize    = "s3-part-size"
+	inputS3Concurrency = "s3-concurrency"
+	inputGCSBucket     = "gcs-bucket-name"
+	inputGCSKeyPrefix  = "gcs-key-prefix"
+	inputGCSURL        = "gcs-url"
+
+	inputAzBlobContainerName    = "azblob-container-name"
+	inputAzBlobKeyPrefix        = "azblob-key-prefix"
+	inputAzBlobAccountURL       = "azblob-account-url"
+	inputAzBlobSASToken         = "azblob-sas-token"
+	inputAzBlobConnectionString = "azblob-connection-string"
+
+	inputOCIRepository = "oci-repository"
+	inputOCIUsername   = "oci-username"
+	inputOCIPassword   = "oci-password"
+	inputOCIPlainHTTP  = "oci-plain-http"
+
+	inputBuildkitdEndpoint      = "buildkitd-endpoint"
+	inputBuildkitdTLSServerName = "buildkitd-tls-server-name"
+	inputBuildkitdTLSCACert     = "buildkitd-tls-ca-cert"
+	inputBuildkitdTLSCert       = "buildkitd-tls-cert"
+	inputBuildkitdTLSKey        = "buildkitd-tls-key"
+	inputBuildkitdStopCommand   = "buildkitd-stop-command"
+	inputBuildkitdStartCommand  = "buildkitd-start-command"
 )
 
-func newManager(ctx context.Context, gha *githubactions.Action) (remote.Manager, error) {
-	remoteType := gha.GetInput(inputRemoteType)
+func newManager(ctx context.Context, runner ci.CI) (remote.Manager, error) {
+	remoteType := runner.GetInput(inputRemoteType)
 
 	switch remoteType {
 	case "gha":
 		manager, err := githubmanager.New()
 		if err != nil {
-			gha.Errorf("Failed to access Github Actions Cache: %+v", err)
+			runner.Errorf("Failed to access Github Actions Cache: %+v", err)
 			return nil, err
 		}
 		return manager, nil
 
 	case "s3":
-		bucketName := gha.GetInput(inputS3BucketName)
+		bucketName := runner.GetInput(inputS3BucketName)
 		if bucketName == "" {
 			err := errors.Errorf(`"%s" is required`, inputS3BucketName)
-			gha.Errorf(err.Error())
+			runner.Errorf(err.Error())
 			return nil, err
 		}
-		keyPrefix := gha.GetInput(inputS3KeyPrefix)
-		customURL := gha.GetInput(inputS3URL)
+		keyPrefix := runner.GetInput(inputS3KeyPrefix)
+		customURL := runner.GetInput(inputS3URL)
 
 		var opts []func(*config.LoadOptions) error
 		if customURL != "" {
@@ -54,15 +86,152 @@ func newManager(ctx context.Context, gha *githubactions.Action) (remote.Manager,
 
 		awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
 		if err != nil {
-			gha.Errorf("Failed to load aws config: %+v", err)
+			runner.Errorf("Failed to load aws config: %+v", err)
+			return nil, err
+		}
+
+		transfer, err := getS3TransferOptions(runner)
+		if err != nil {
 			return nil, err
 		}
 
-		return s3manager.New(awsCfg, bucketName, keyPrefix, customURL != ""), nil
+		return s3manager.New(awsCfg, bucketName, keyPrefix, customURL != "", transfer), nil
+
+	case "gcs":
+		bucketName := runner.GetInput(inputGCSBucket)
+		if bucketName == "" {
+			err := errors.Errorf(`"%s" is required`, inputGCSBucket)
+			runner.Errorf(err.Error())
+			return nil, err
+		}
+		keyPrefix := runner.GetInput(inputGCSKeyPrefix)
+
+		var opts []option.ClientOption
+		if customURL := runner.GetInput(inputGCSURL); customURL != "" {
+			opts = append(opts, option.WithEndpoint(customURL))
+		}
+
+		manager, err := gcsmanager.New(ctx, bucketName, keyPrefix, opts...)
+		if err != nil {
+			runner.Errorf("Failed to access Google Cloud Storage: %+v", err)
+			return nil, err
+		}
+		return manager, nil
+
+	case "azblob":
+		return newAzBlobManager(runner)
+
+	case "oci":
+		return newOCIManager(runner)
+
+	case "local":
+		return localmanager.New(runner.GetInput(inputLocalPath)), nil
 
 	default:
-		err := errors.Errorf("unknown remote-type: %v. Only supports `gha` or `s3`", remoteType)
-		gha.Errorf(err.Error())
+		err := errors.Errorf(
+			"unknown remote-type: %v. Only supports `gha`, `s3`, `gcs`, `azblob`, `oci` or `local`",
+			remoteType,
+		)
+		runner.Errorf(err.Error())
+		return nil, err
+	}
+}
+
+func getS3TransferOptions(runner ci.CI) (s3manager.TransferOptions, error) {
+	var transfer s3manager.TransferOptions
+
+	if rawPartSize := runner.GetInput(inputS3PartSize); rawPartSize != "" {
+		partSize, err := units.RAMInBytes(rawPartSize)
+		if err != nil {
+			runner.Errorf(`Failed to parse "%s": %+v`, inputS3PartSize, err)
+			return transfer, errors.WithStack(err)
+		}
+		if partSize < s3manager.MinPartSize {
+			err = errors.Errorf(`"%s" must be at least %d bytes`, inputS3PartSize, s3manager.MinPartSize)
+			runner.Errorf(err.Error())
+			return transfer, err
+		}
+		transfer.PartSize = partSize
+	}
+
+	if rawConcurrency := runner.GetInput(inputS3Concurrency); rawConcurrency != "" {
+		concurrency, err := strconv.Atoi(rawConcurrency)
+		if err != nil {
+			runner.Errorf(`Failed to parse "%s": %+v`, inputS3Concurrency, err)
+			return transfer, errors.WithStack(err)
+		}
+		if concurrency < 1 {
+			err = errors.Errorf(`"%s" must be positive`, inputS3Concurrency)
+			runner.Errorf(err.Error())
+			return transfer, err
+		}
+		transfer.Concurrency = concurrency
+	}
+
+	return transfer, nil
+}
+
+func newAzBlobManager(runner ci.CI) (remote.Manager, error) {
+	containerName := runner.GetInput(inputAzBlobContainerName)
+	if containerName == "" {
+		err := errors.Errorf(`"%s" is required`, inputAzBlobContainerName)
+		runner.Errorf(err.Error())
+		return nil, err
+	}
+	keyPrefix := runner.GetInput(inputAzBlobKeyPrefix)
+
+	var manager azblobmanager.Manager
+	var err error
+	if connectionString := runner.GetInput(inputAzBlobConnectionString); connectionString != "" {
+		manager, err = azblobmanager.NewFromConnectionString(connectionString, containerName, keyPrefix)
+	} else {
+		accountURL := runner.GetInput(inputAzBlobAccountURL)
+		sasToken := runner.GetInput(inputAzBlobSASToken)
+		if accountURL == "" || sasToken == "" {
+			err = errors.Errorf(
+				`Either "%s" or both "%s" and "%s" are required`,
+				inputAzBlobConnectionString,
+				inputAzBlobAccountURL,
+				inputAzBlobSASToken,
+			)
+			runner.Errorf(err.Error())
+			return nil, err
+		}
+		manager, err = azblobmanager.NewFromSAS(accountURL, containerName, sasToken, keyPrefix)
+	}
+	if err != nil {
+		runner.Errorf("Failed to access Azure Blob Storage: %+v", err)
+		return nil, err
+	}
+
+	return manager, nil
+}
+
+func newOCIManager(runner ci.CI) (remote.Manager, error) {
+	repository := runner.GetInput(inputOCIRepository)
+	if repository == "" {
+		err := errors.Errorf(`"%s" is required`, inputOCIRepository)
+		runner.Errorf(err.Error())
+		return nil, err
+	}
+
+	opts := ocimanager.Options{
+		Username: runner.GetInput(inputOCIUsername),
+		Password: runner.GetInput(inputOCIPassword),
+	}
+	if rawPlainHTTP := runner.GetInput(inputOCIPlainHTTP); rawPlainHTTP != "" {
+		plainHTTP, err := strconv.ParseBool(rawPlainHTTP)
+		if err != nil {
+			runner.Errorf(`Failed to parse "%s": %+v`, inputOCIPlainHTTP, err)
+			return nil, errors.WithStack(err)
+		}
+		opts.PlainHTTP = plainHTTP
+	}
+
+	manager, err := ocimanager.New(repository, opts)
+	if err != nil {
+		runner.Errorf("Failed to access OCI registry: %+v", err)
 		return nil, err
 	}
+	return manager, nil
 }
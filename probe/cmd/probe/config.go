@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/isac322/buildkit-state/probe/internal"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// names of inputs that only the command line reads.
+	// Inputs of the workflows are named by internal.Input*, which are the same with action.yaml.
+	inputDebug       = "debug"
+	inputGCAfterSave = "gc-after-save"
+
+	inputDockerEndpoint = "docker-endpoint"
+	inputLocalPath      = "local-path"
+
+	flagConfig = "config"
+	envPrefix  = "BUILDKIT_STATE"
+	// configName is searched in the working directory if config file is not given.
+	configName = "buildkit-state"
+)
+
+// registerFlags defines every input as a persistent flag, so that any of them can also come from
+// environment variables and the config file under the same name.
+func registerFlags(flags *pflag.FlagSet) {
+	flags.String(flagConfig, "", "config file in YAML or TOML (default ./buildkit-state.{yaml,toml} if exists)")
+
+	flags.StringP(internal.InputPrimaryKey, "c", "", "cache key")
+	flags.StringSliceP(internal.InputSecondaryKeys, "r", nil, "keys to restore if cache-key does not match")
+	flags.StringSliceP(
+		internal.InputTargetTypes,
+		"t",
+		[]string{"exec.cachemount", "frontend"},
+		"buildkit state types to save",
+	)
+	flags.Bool(internal.InputRewriteCache, false, "overwrite cache even if it is restored from the same key")
+	flags.Bool(internal.InputResumeBuilder, true, "resume buildkitd after loading")
+	flags.String(internal.InputCompression, string(internal.CodecZstd), "compression codec (zstd, lz4, gzip or none)")
+	flags.IntP(internal.InputCompressionLevel, "l", 3, "compression level")
+	flags.Int(
+		internal.InputWindowSize,
+		internal.DefaultWindowLog,
+		fmt.Sprintf("log2 of zstd window size (from %d to %d)", internal.MinWindowLog, internal.MaxWindowLog),
+	)
+	flags.String(internal.InputStateDir, "", "state directory of buildkitd (detected if empty)")
+	flags.Int(
+		internal.InputMaxRestoreAttempts,
+		internal.DefaultMaxRestoreAttempts,
+		"number of caches to try until one is restored",
+	)
+	flags.Bool(inputDebug, false, "print debug logs")
+	flags.StringArray(
+		internal.InputRetention,
+		nil,
+		"retention policy of gc (e.g. prefix=Linux-buildkit_state-,keep=5,max-age=7d,max-size=10GiB), repeatable",
+	)
+	flags.Bool(inputGCAfterSave, false, "run gc after save")
+
+	flags.StringP(inputBuildxName, "b", "", "buildx builder name")
+	flags.String(inputDriver, "", "buildx driver (docker-container, kubernetes or remote; detected if empty)")
+	flags.StringP(inputDockerEndpoint, "e", client.DefaultDockerHost, "endpoint of docker daemon")
+	flags.String(inputBuildkitdEndpoint, "", "endpoint of buildkitd for remote driver")
+	flags.String(inputBuildkitdTLSServerName, "", "server name of buildkitd certificate for remote driver")
+	flags.String(inputBuildkitdTLSCACert, "", "CA certificate of buildkitd for remote driver")
+	flags.String(inputBuildkitdTLSCert, "", "client certificate for remote driver")
+	flags.String(inputBuildkitdTLSKey, "", "client key for remote driver")
+	flags.String(inputBuildkitdStopCommand, "", "shell command to stop buildkitd for remote driver")
+	flags.String(inputBuildkitdStartCommand, "", "shell command to start buildkitd for remote driver")
+
+	flags.String(inputRemoteType, "", "remote storage (gha, s3, gcs, azblob, oci or local)")
+	flags.String(inputS3BucketName, "", "S3 bucket")
+	flags.String(inputS3KeyPrefix, "", "prefix of S3 object keys")
+	flags.String(inputS3URL, "", "custom S3 endpoint (e.g. MinIO)")
+	flags.String(inputS3PartSize, "", "part size of S3 multipart upload (e.g. 64MiB)")
+	flags.String(inputS3Concurrency, "", "number of concurrent S3 part uploads and downloads")
+	flags.String(inputGCSBucket, "", "Google Cloud Storage bucket")
+	flags.String(inputGCSKeyPrefix, "", "prefix of Google Cloud Storage object names")
+	flags.String(inputGCSURL, "", "custom Google Cloud Storage endpoint")
+	flags.String(inputAzBlobContainerName, "", "Azure Blob Storage container")
+	flags.String(inputAzBlobKeyPrefix, "", "prefix of Azure blob names")
+	flags.String(inputAzBlobAccountURL, "", "Azure storage account URL")
+	flags.String(inputAzBlobSASToken, "", "Azure SAS token")
+	flags.String(inputAzBlobConnectionString, "", "Azure storage connection string")
+	flags.String(inputOCIRepository, "", "OCI repository (e.g. ghcr.io/owner/cache)")
+	flags.String(inputOCIUsername, "", "username of OCI registry")
+	flags.String(inputOCIPassword, "", "password of OCI registry")
+	flags.String(inputOCIPlainHTTP, "", "access OCI registry by plain HTTP")
+	flags.StringP(inputLocalPath, "d", ".", "cache directory of local remote")
+}
+
+// loadConfig merges flags, `BUILDKIT_STATE_*` environment variables and the config file in order of precedence.
+func loadConfig(flags *pflag.FlagSet) (*viper.Viper, error) {
+	v := viper.New()
+	if err := v.BindPFlags(flags); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	if configFile := v.GetString(flagConfig); configFile != "" {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return v, nil
+	}
+
+	v.SetConfigName(configName)
+	v.AddConfigPath(".")
+	if err := v.ReadInConfig(); err != nil && !errors.As(err, &viper.ConfigFileNotFoundError{}) {
+		return nil, errors.WithStack(err)
+	}
+	return v, nil
+}
+
+// newCI detects CI and overrides its inputs by v.
+// On GitHub Actions, defaults of flags are not used, because action.yaml has its own defaults.
+func newCI(v *viper.Viper, flags *pflag.FlagSet) ci.CI {
+	onGitHub := ci.IsGitHubActions()
+
+	inputs := make(map[string]string)
+	for _, key := range v.AllKeys() {
+		if key == flagConfig || (onGitHub && !v.IsSet(key)) {
+			continue
+		}
+		flag := flags.Lookup(key)
+		switch {
+		case flag != nil && flag.Changed && flag.Value.Type() == "stringArray":
+			// viper splits each value of stringArray by comma
+			inputs[key] = strings.Join(flag.Value.(pflag.SliceValue).GetSlice(), "\n")
+		case flag != nil && (flag.Value.Type() == "stringSlice" || flag.Value.Type() == "stringArray"):
+			inputs[key] = strings.Join(v.GetStringSlice(key), "\n")
+		default:
+			inputs[key] = v.GetString(key)
+		}
+	}
+	return ci.Detect(inputs)
+}
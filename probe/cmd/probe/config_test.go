@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigPrecedence is not parallel, because it sets environment variables.
+func TestConfigPrecedence(t *testing.T) {
+	configs := map[string]string{
+		"buildkit-state.yaml": "cache-key: from-config\ncompression-level: 5\nremote-type: s3\n" +
+			"target-types:\n  - exec.cachemount\n  - regular\n" +
+			"retention:\n  - prefix=a-,keep=1\n  - max-age=7d\n",
+		"buildkit-state.toml": "cache-key = \"from-config\"\ncompression-level = 5\nremote-type = \"s3\"\n" +
+			"target-types = [\"exec.cachemount\", \"regular\"]\n" +
+			"retention = [\"prefix=a-,keep=1\", \"max-age=7d\"]\n",
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "defaults",
+			args: nil,
+			env:  nil,
+			expected: map[string]string{
+				internal.InputPrimaryKey:       "from-config",
+				internal.InputCompressionLevel: "5",
+				internal.InputTargetTypes:      "exec.cachemount\nregular",
+				internal.InputResumeBuilder:    "true",
+				inputRemoteType:                "s3",
+				inputS3BucketName:              "",
+				internal.InputRetention:        "prefix=a-,keep=1\nmax-age=7d",
+			},
+		},
+		{
+			name: "environment variables over config file",
+			args: nil,
+			env: map[string]string{
+				"BUILDKIT_STATE_CACHE_KEY":      "from-env",
+				"BUILDKIT_STATE_S3_BUCKET_NAME": "bucket",
+				"BUILDKIT_STATE_RETENTION":      "keep=2 max-age=1d",
+			},
+			expected: map[string]string{
+				internal.InputPrimaryKey:       "from-env",
+				internal.InputCompressionLevel: "5",
+				internal.InputTargetTypes:      "exec.cachemount\nregular",
+				internal.InputResumeBuilder:    "true",
+				inputRemoteType:                "s3",
+				inputS3BucketName:              "bucket",
+				internal.InputRetention:        "keep=2\nmax-age=1d",
+			},
+		},
+		{
+			name: "flags over environment variables",
+			args: []string{
+				"--cache-key=from-flag", "-t", "frontend", "--resume-builder=false",
+				"--retention", "prefix=b-,keep=3", "--retention", "max-size=1GiB",
+			},
+			env: map[string]string{
+				"BUILDKIT_STATE_CACHE_KEY":      "from-env",
+				"BUILDKIT_STATE_S3_BUCKET_NAME": "bucket",
+				"BUILDKIT_STATE_RETENTION":      "keep=2 max-age=1d",
+			},
+			expected: map[string]string{
+				internal.InputPrimaryKey:       "from-flag",
+				internal.InputCompressionLevel: "5",
+				internal.InputTargetTypes:      "frontend",
+				internal.InputResumeBuilder:    "false",
+				inputRemoteType:                "s3",
+				inputS3BucketName:              "bucket",
+				internal.InputRetention:        "prefix=b-,keep=3\nmax-size=1GiB",
+			},
+		},
+	}
+
+	for configName, config := range configs {
+		configFile := filepath.Join(t.TempDir(), configName)
+		require.NoError(t, os.WriteFile(configFile, []byte(config), 0o600))
+
+		for _, tt := range tests {
+			t.Run(configName+"/"+tt.name, func(t *testing.T) {
+				t.Setenv("GITHUB_ACTIONS", "")
+				t.Setenv("GITLAB_CI", "")
+				for key, value := range tt.env {
+					t.Setenv(key, value)
+				}
+
+				flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+				registerFlags(flags)
+				require.NoError(t, flags.Parse(append([]string{"--config", configFile}, tt.args...)))
+
+				v, err := loadConfig(flags)
+				require.NoError(t, err)
+				runner := newCI(v, flags)
+				for name, expected := range tt.expected {
+					assert.Equal(t, expected, runner.GetInput(name), name)
+				}
+			})
+		}
+	}
+}
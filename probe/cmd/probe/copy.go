@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/isac322/buildkit-state/probe/internal"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	flagFrom = "from"
+	flagTo   = "to"
+	flagKeys = "keys"
+)
+
+var copyCmd = &cobra.Command{
+	Use:   "copy --from <remote-spec> --to <remote-spec>",
+	Args:  cobra.NoArgs,
+	Short: "Copy buildkit states between remotes without decompressing them",
+	Long: `Copy buildkit states between remotes without decompressing them, preserving keys and metadata.
+
+A remote spec is either a remote type (e.g. "s3"), which is configured by the other flags as usual,
+or an URL that overrides location of the remote:
+  s3://<bucket>/<key prefix>
+  gcs://<bucket>/<key prefix>
+  azblob://<container>/<key prefix>
+  oci://<registry>/<repository>
+  local:<path>
+Query parameters set the other options of the remote, e.g. "s3://bucket?url=http://localhost:9000"
+sets --s3-url and "oci://localhost:5000/cache?plain-http=true" sets --oci-plain-http.`,
+	RunE: copyCaches,
+}
+
+// remoteSpecParams are options of each remote type that query parameters of remote spec can set.
+var remoteSpecParams = map[string][]string{
+	"gha":    {},
+	"s3":     {"url", "part-size", "concurrency"},
+	"gcs":    {"url"},
+	"azblob": {"account-url", "sas-token", "connection-string"},
+	"oci":    {"username", "password", "plain-http"},
+	"local":  {},
+}
+
+func init() {
+	copyCmd.Flags().String(flagFrom, "", "remote spec of source")
+	copyCmd.Flags().String(flagTo, "", "remote spec of destination")
+	copyCmd.Flags().String(flagKeys, "", "copy only caches whose key starts with this prefix")
+	copyCmd.Flags().Bool(internal.InputDryRun, false, "only print caches to be copied")
+	_ = copyCmd.MarkFlagRequired(flagFrom)
+	_ = copyCmd.MarkFlagRequired(flagTo)
+}
+
+func copyCaches(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	from, err := cmd.Flags().GetString(flagFrom)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	to, err := cmd.Flags().GetString(flagTo)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	prefix, err := cmd.Flags().GetString(flagKeys)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	srcInputs, err := parseRemoteSpec(from)
+	if err != nil {
+		return err
+	}
+	dstInputs, err := parseRemoteSpec(to)
+	if err != nil {
+		return err
+	}
+
+	runner, err := newCIFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	src, err := newManager(ctx, ci.WithInputs(runner, srcInputs))
+	if err != nil {
+		return err
+	}
+	dst, err := newManager(ctx, ci.WithInputs(runner, dstInputs))
+	if err != nil {
+		return err
+	}
+
+	return internal.CopyBetweenRemotes(ctx, runner, src, dst, prefix)
+}
+
+// parseRemoteSpec converts remote spec into inputs that override the ones of newManager.
+func parseRemoteSpec(spec string) (map[string]string, error) {
+	if _, found := remoteSpecParams[spec]; found {
+		return map[string]string{inputRemoteType: spec}, nil
+	}
+
+	parsed, err := url.Parse(spec)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	remoteType := parsed.Scheme
+	params, found := remoteSpecParams[remoteType]
+	if !found {
+		return nil, errors.Errorf("unsupported remote spec: %s", spec)
+	}
+
+	inputs := map[string]string{inputRemoteType: remoteType}
+	location := parsed.Host
+	keyPrefix := strings.TrimPrefix(parsed.Path, "/")
+	switch remoteType {
+	case "s3":
+		inputs[inputS3BucketName] = location
+		inputs[inputS3KeyPrefix] = keyPrefix
+	case "gcs":
+		inputs[inputGCSBucket] = location
+		inputs[inputGCSKeyPrefix] = keyPrefix
+	case "azblob":
+		inputs[inputAzBlobContainerName] = location
+		inputs[inputAzBlobKeyPrefix] = keyPrefix
+	case "oci":
+		location = strings.TrimSuffix(location+parsed.Path, "/")
+		inputs[inputOCIRepository] = location
+	case "local":
+		// both `local:<path>` and `local://<path>` are accepted
+		location = parsed.Opaque
+		if location == "" {
+			location = parsed.Host + parsed.Path
+		}
+		inputs[inputLocalPath] = location
+	default:
+		return nil, errors.Errorf("remote type %s can not have location: %s", remoteType, spec)
+	}
+	if location == "" {
+		return nil, errors.Errorf("remote spec does not have location: %s", spec)
+	}
+
+	for name, values := range parsed.Query() {
+		if !slices.Contains(params, name) {
+			return nil, errors.Errorf("unsupported parameter %s of remote type %s", name, remoteType)
+		}
+		inputs[remoteType+"-"+name] = values[len(values)-1]
+	}
+	return inputs, nil
+}
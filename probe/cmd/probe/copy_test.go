@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRemoteSpec(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		spec          string
+		expected      map[string]string
+		expectedError bool
+	}{
+		{
+			name:     "type only",
+			spec:     "gha",
+			expected: map[string]string{inputRemoteType: "gha"},
+		},
+		{
+			name: "s3",
+			spec: "s3://bucket/path/to?url=http://localhost:9000&part-size=16MiB",
+			expected: map[string]string{
+				inputRemoteType:   "s3",
+				inputS3BucketName: "bucket",
+				inputS3KeyPrefix:  "path/to",
+				inputS3URL:        "http://localhost:9000",
+				inputS3PartSize:   "16MiB",
+			},
+		},
+		{
+			name:     "gcs without prefix",
+			spec:     "gcs://bucket",
+			expected: map[string]string{inputRemoteType: "gcs", inputGCSBucket: "bucket", inputGCSKeyPrefix: ""},
+		},
+		{
+			name: "azblob",
+			spec: "azblob://container/prefix",
+			expected: map[string]string{
+				inputRemoteType:          "azblob",
+				inputAzBlobContainerName: "container",
+				inputAzBlobKeyPrefix:     "prefix",
+			},
+		},
+		{
+			name: "oci",
+			spec: "oci://localhost:5000/org/cache?plain-http=true",
+			expected: map[string]string{
+				inputRemoteType:    "oci",
+				inputOCIRepository: "localhost:5000/org/cache",
+				inputOCIPlainHTTP:  "true",
+			},
+		},
+		{
+			name:     "local relative path",
+			spec:     "local:cache/dir",
+			expected: map[string]string{inputRemoteType: "local", inputLocalPath: "cache/dir"},
+		},
+		{
+			name:     "local absolute path",
+			spec:     "local:///tmp/cache",
+			expected: map[string]string{inputRemoteType: "local", inputLocalPath: "/tmp/cache"},
+		},
+		{name: "unknown type", spec: "ftp://host/path", expectedError: true},
+		{name: "unknown parameter", spec: "s3://bucket?region=us-east-1", expectedError: true},
+		{name: "gha with location", spec: "gha://repo", expectedError: true},
+		{name: "missing location", spec: "s3:///prefix", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			inputs, err := parseRemoteSpec(tt.spec)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, inputs)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/isac322/buildkit-state/probe/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Args:  cobra.NoArgs,
+	Short: "Delete buildkit states in remote that retention policies do not keep",
+	Long: `Delete buildkit states in remote that retention policies do not keep.
+
+Each policy applies to caches whose key starts with its prefix, and the one of the longest prefix is chosen.
+A cache is deleted if it is not one of the newest "keep" caches, is older than "max-age",
+or does not fit in "max-size" that is filled from the newest cache. Caches matched by no policy are kept.
+
+Keys of builders of multiple nodes are prefixed by the platform of each node (e.g. "linux-arm64_"),
+so prefixes of policies for them have to include it.`,
+	RunE: gc,
+}
+
+func init() {
+	gcCmd.Flags().Bool(internal.InputDryRun, false, "only print caches to be deleted")
+}
+
+func gc(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	runner, err := newCIFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	manager, err := newManager(ctx, runner)
+	if err != nil {
+		return err
+	}
+
+	return internal.CollectGarbage(ctx, runner, nil, manager)
+}
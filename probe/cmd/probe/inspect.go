@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal"
+
+	"github.com/docker/go-units"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <key>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Show snapshots and cache mounts in a buildkit state stored in remote without restoring it",
+	RunE:  inspect,
+}
+
+func init() {
+	inspectCmd.Flags().StringP(flagOutput, "o", outputTable, "output format (table or json)")
+}
+
+func inspect(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	key := args[0]
+	format, err := cmd.Flags().GetString(flagOutput)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if format != outputTable && format != outputJSON {
+		return errors.Errorf("unsupported output format: %s. Only supports `%s` or `%s`", format, outputTable, outputJSON)
+	}
+
+	runner, err := newCIFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	manager, err := newManager(ctx, runner)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := manager.Load(ctx, key, nil)
+	if err != nil {
+		runner.Errorf("Failed to find cache: %+v", err)
+		return err
+	}
+	if len(candidates) == 0 {
+		err = errors.Errorf("cache %s is not found", key)
+		runner.Errorf(err.Error())
+		return err
+	}
+
+	loaded, err := candidates[0].Open(ctx)
+	if err != nil {
+		runner.Errorf("Failed to open cache: %+v", err)
+		return err
+	}
+	defer loaded.Data.Close()
+
+	inspection, err := internal.Inspect(loaded)
+	if err != nil {
+		runner.Errorf("Failed to inspect cache: %+v", err)
+		return err
+	}
+
+	return printInspection(cmd.OutOrStdout(), inspection, format)
+}
+
+func printInspection(w io.Writer, inspection internal.Inspection, format string) error {
+	if format == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return errors.WithStack(encoder.Encode(inspection))
+	}
+
+	checksum := "not recorded"
+	if inspection.Verified {
+		checksum = "verified"
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(table, "Key:\t%s\n", inspection.Key)
+	_, _ = fmt.Fprintf(
+		table,
+		"Compressed:\t%s (%s)\n",
+		units.HumanSize(float64(inspection.CompressedSize)),
+		inspection.Codec,
+	)
+	_, _ = fmt.Fprintf(table, "Size:\t%s in %d files\n", units.HumanSize(float64(inspection.Size)), inspection.Files)
+	_, _ = fmt.Fprintf(table, "Checksum:\t%s\n", checksum)
+	if manifest := inspection.Manifest; manifest != nil {
+		_, _ = fmt.Fprintf(table, "Buildkit:\t%s\n", orDash(manifest.BuildkitVersion))
+		_, _ = fmt.Fprintf(table, "Snapshotter:\t%s\n", orDash(manifest.Snapshotter))
+		_, _ = fmt.Fprintf(table, "Platform:\t%s\n", orDash(manifest.Platform))
+		_, _ = fmt.Fprintf(table, "Targets:\t%s\n", orDash(strings.Join(manifest.TargetTypes, ",")))
+		_, _ = fmt.Fprintf(table, "Created:\t%s\n", formatTime(manifest.CreatedAt))
+	} else {
+		_, _ = fmt.Fprintf(table, "Manifest:\t-\n")
+	}
+	if err := table.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, _ = io.WriteString(w, "\n")
+	table = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = io.WriteString(table, "SNAPSHOTTER\tSNAPSHOT\tFILES\tSIZE\n")
+	for _, snapshot := range inspection.Snapshots {
+		_, _ = fmt.Fprintf(
+			table,
+			"%s\t%s\t%d\t%s\n",
+			snapshot.Snapshotter,
+			snapshot.ID,
+			snapshot.Files,
+			units.HumanSize(float64(snapshot.Size)),
+		)
+	}
+	if err := table.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, _ = io.WriteString(w, "\n")
+	table = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = io.WriteString(table, "WORKER\tCACHE MOUNT\tRECORD\tSIZE\tUSAGE\tLAST USED\n")
+	for _, mount := range inspection.CacheMounts {
+		size := "-"
+		if mount.Size >= 0 {
+			size = units.HumanSize(float64(mount.Size))
+		}
+		_, _ = fmt.Fprintf(
+			table,
+			"%s\t%s\t%s\t%s\t%d\t%s\n",
+			mount.Worker,
+			mount.ID,
+			mount.RecordID,
+			size,
+			mount.UsageCount,
+			formatTime(mount.LastUsedAt),
+		)
+	}
+	return errors.WithStack(table.Flush())
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintInspection(t *testing.T) {
+	t.Parallel()
+
+	inspection := internal.Inspection{
+		Key:            "key",
+		CompressedSize: 1000,
+		Size:           4000,
+		Files:          3,
+		Codec:          internal.CodecZstd,
+		Verified:       true,
+		Manifest: &remote.Manifest{
+			BuildkitVersion: "v0.11.6",
+			Snapshotter:     "overlayfs",
+			Platform:        "linux/amd64",
+			TargetTypes:     []string{"exec.cachemount"},
+			CreatedAt:       time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC),
+		},
+		Snapshots: []internal.SnapshotUsage{{Snapshotter: "runc-overlayfs", ID: "12", Files: 2, Size: 3000}},
+		CacheMounts: []internal.CacheMount{
+			{
+				Worker:     "runc-overlayfs",
+				ID:         "/root/.cache",
+				RecordID:   "abc",
+				Size:       3000,
+				UsageCount: 2,
+				LastUsedAt: time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC),
+			},
+			{Worker: "runc-overlayfs", ID: "apt", RecordID: "def", Size: -1},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, printInspection(&buf, inspection, outputTable))
+	assert.Equal(t, ""+
+		"Key:          key\n"+
+		"Compressed:   1kB (zstd)\n"+
+		"Size:         4kB in 3 files\n"+
+		"Checksum:     verified\n"+
+		"Buildkit:     v0.11.6\n"+
+		"Snapshotter:  overlayfs\n"+
+		"Platform:     linux/amd64\n"+
+		"Targets:      exec.cachemount\n"+
+		"Created:      2023-07-01T00:00:00Z\n"+
+		"\n"+
+		"SNAPSHOTTER     SNAPSHOT  FILES  SIZE\n"+
+		"runc-overlayfs  12        2      3kB\n"+
+		"\n"+
+		"WORKER          CACHE MOUNT   RECORD  SIZE  USAGE  LAST USED\n"+
+		"runc-overlayfs  /root/.cache  abc     3kB   2      2023-07-02T00:00:00Z\n"+
+		"runc-overlayfs  apt           def     -     0      -\n",
+		buf.String(),
+	)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/docker/go-units"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	flagPrefix = "prefix"
+	flagOutput = "output"
+
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Args:  cobra.NoArgs,
+	Short: "List buildkit states stored in remote",
+	RunE:  list,
+}
+
+func init() {
+	listCmd.Flags().String(
+		flagPrefix,
+		"",
+		"list only caches whose key starts with this. "+
+			"Keys of builders of multiple nodes are prefixed by the platform (e.g. linux-arm64_)",
+	)
+	listCmd.Flags().StringP(flagOutput, "o", outputTable, "output format (table or json)")
+}
+
+// listedCache is JSON representation of remote.Entry.
+type listedCache struct {
+	Key      string           `json:"key"`
+	Size     int64            `json:"size"`
+	Created  time.Time        `json:"created"`
+	Codec    string           `json:"codec,omitempty"`
+	Manifest *remote.Manifest `json:"manifest,omitempty"`
+}
+
+func list(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	prefix, err := cmd.Flags().GetString(flagPrefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	format, err := cmd.Flags().GetString(flagOutput)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if format != outputTable && format != outputJSON {
+		return errors.Errorf("unsupported output format: %s. Only supports `%s` or `%s`", format, outputTable, outputJSON)
+	}
+
+	runner, err := newCIFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	manager, err := newManager(ctx, runner)
+	if err != nil {
+		return err
+	}
+	lister, ok := manager.(remote.Lister)
+	if !ok {
+		err = errors.Errorf("remote-type %s does not support listing", runner.GetInput(inputRemoteType))
+		runner.Errorf(err.Error())
+		return err
+	}
+
+	entries, err := lister.List(ctx, prefix)
+	if err != nil {
+		runner.Errorf("Failed to list caches: %+v", err)
+		return err
+	}
+
+	return printEntries(cmd.OutOrStdout(), entries, format)
+}
+
+// printEntries prints entries from the newest one.
+func printEntries(w io.Writer, entries []remote.Entry, format string) error {
+	caches := make([]listedCache, 0, len(entries))
+	for _, entry := range entries {
+		cache := listedCache{
+			Key:     entry.Key,
+			Size:    entry.Size,
+			Created: entry.Modified,
+			Codec:   entry.Metadata[internal.MetadataCodec],
+		}
+		// malformed manifest is printed as missing one, like Load handles it as a cache of old versions
+		if manifest, err := entry.Metadata.Manifest(); err == nil {
+			if value, found := manifest.Get(); found {
+				cache.Manifest = &value
+			}
+		}
+		caches = append(caches, cache)
+	}
+	slices.SortFunc(caches, func(a, b listedCache) bool {
+		if !a.Created.Equal(b.Created) {
+			return a.Created.After(b.Created)
+		}
+		return a.Key < b.Key
+	})
+
+	if format == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return errors.WithStack(encoder.Encode(caches))
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = io.WriteString(table, "KEY\tSIZE\tCREATED\tCODEC\tBUILDKIT\tSNAPSHOTTER\tPLATFORM\tTARGETS\n")
+	for _, cache := range caches {
+		size := "-"
+		if cache.Size >= 0 {
+			size = units.HumanSize(float64(cache.Size))
+		}
+		var buildkitVersion, snapshotter, platform, targets string
+		if cache.Manifest != nil {
+			buildkitVersion = cache.Manifest.BuildkitVersion
+			snapshotter = cache.Manifest.Snapshotter
+			platform = cache.Manifest.Platform
+			targets = strings.Join(cache.Manifest.TargetTypes, ",")
+		}
+		_, _ = io.WriteString(table, strings.Join([]string{
+			cache.Key,
+			size,
+			formatTime(cache.Created),
+			orDash(cache.Codec),
+			orDash(buildkitVersion),
+			orDash(snapshotter),
+			orDash(platform),
+			orDash(targets),
+		}, "\t")+"\n")
+	}
+	return errors.WithStack(table.Flush())
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintEntries(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	withManifest := remote.Metadata{"codec": "zstd"}
+	require.NoError(t, withManifest.SetManifest(remote.Manifest{
+		BuildkitVersion: "v0.11.6",
+		Snapshotter:     "overlayfs",
+		Platform:        "linux/amd64",
+		TargetTypes:     []string{"exec.cachemount", "frontend"},
+	}))
+	entries := []remote.Entry{
+		{Key: "old", Size: -1, Modified: created, Metadata: remote.Metadata{}},
+		{Key: "new", Size: 2048, Modified: created.Add(time.Hour), Metadata: withManifest},
+		{Key: "broken", Size: 0, Modified: created, Metadata: remote.Metadata{remote.MetadataManifest: "{"}},
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{
+			name:   "table",
+			format: outputTable,
+			expected: "" +
+				"KEY     SIZE     CREATED               CODEC  BUILDKIT  SNAPSHOTTER  PLATFORM     TARGETS\n" +
+				"new     2.048kB  2023-07-01T01:00:00Z  zstd   v0.11.6   overlayfs    linux/amd64  exec.cachemount,frontend\n" +
+				"broken  0B       2023-07-01T00:00:00Z  -      -         -            -            -\n" +
+				"old     -        2023-07-01T00:00:00Z  -      -         -            -            -\n",
+		},
+		{
+			name:   "json",
+			format: outputJSON,
+			expected: `[
+  {
+    "key": "new",
+    "size": 2048,
+    "created": "2023-07-01T01:00:00Z",
+    "codec": "zstd",
+    "manifest": {
+      "buildkit_version": "v0.11.6",
+      "snapshotter": "overlayfs",
+      "platform": "linux/amd64",
+      "target_types": [
+        "exec.cachemount",
+        "frontend"
+      ],
+      "created_at": "0001-01-01T00:00:00Z",
+      "probe_version": ""
+    }
+  },
+  {
+    "key": "broken",
+    "size": 0,
+    "created": "2023-07-01T00:00:00Z"
+  },
+  {
+    "key": "old",
+    "size": -1,
+    "created": "2023-07-01T00:00:00Z"
+  }
+]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			require.NoError(t, printEntries(&buf, entries, tt.format))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
@@ -3,15 +3,16 @@ package main
 import (
 	"context"
 	"log"
+	"strconv"
 
 	"github.com/isac322/buildkit-state/probe/internal"
 	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
 	"github.com/isac322/buildkit-state/probe/internal/remote"
 
 	"github.com/docker/docker/client"
 	"github.com/goccy/go-json"
 	"github.com/pkg/errors"
-	"github.com/sethvargo/go-githubactions"
 	"github.com/spf13/cobra"
 	_ "go.uber.org/automaxprocs"
 )
@@ -20,6 +21,12 @@ var (
 	rootCmd = &cobra.Command{
 		Use:   "buildkit-state",
 		Short: "Manage buildkit state intrusively",
+		Long: `Manage buildkit state intrusively.
+
+Every option can also be given by environment variable in upper snake case prefixed by BUILDKIT_STATE_
+(e.g. BUILDKIT_STATE_CACHE_KEY for --cache-key) or by key of the config file (e.g. cache-key).
+Flags take precedence over environment variables, which take precedence over the config file.`,
+		SilenceUsage: true,
 	}
 	loadCmd = &cobra.Command{
 		Use:   "load",
@@ -33,21 +40,19 @@ var (
 		Short: "Extract and update buildkit state to remote",
 		RunE:  save,
 	}
-
-	dockerEndpoint string
 )
 
 func init() {
 	rootCmd.AddCommand(loadCmd)
 	rootCmd.AddCommand(saveCmd)
-
-	rootCmd.PersistentFlags().StringVarP(
-		&dockerEndpoint,
-		"docker-endpoint",
-		"e",
-		client.DefaultDockerHost,
-		"Endpoint of docker daemon",
-	)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(gcCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(copyCmd)
+
+	registerFlags(rootCmd.PersistentFlags())
 }
 
 func main() {
@@ -57,56 +62,159 @@ func main() {
 }
 
 func load(cmd *cobra.Command, _ []string) error {
-	return run(cmd.Context(), internal.LoadFromRemoteToContainer)
+	return run(cmd, internal.LoadFromRemoteToContainer)
 }
 
 func save(cmd *cobra.Command, _ []string) error {
-	return run(cmd.Context(), internal.SaveFromContainerToRemote)
+	return run(cmd, saveAndCollectGarbage)
+}
+
+// saveAndCollectGarbage runs gc after saving if `gc-after-save` is set.
+// Failure of gc does not fail the save, because the cache is already saved.
+func saveAndCollectGarbage(ctx context.Context, runner ci.CI, nodes []buildkit.Node, manager remote.Manager) error {
+	if err := internal.SaveFromContainerToRemote(ctx, runner, nodes, manager); err != nil {
+		return err
+	}
+
+	rawGCAfterSave := runner.GetInput(inputGCAfterSave)
+	if rawGCAfterSave == "" {
+		return nil
+	}
+	gcAfterSave, err := strconv.ParseBool(rawGCAfterSave)
+	if err != nil {
+		runner.Warningf(`Ignoring invalid "%s": %+v`, inputGCAfterSave, err)
+		return nil
+	}
+	if gcAfterSave {
+		// errors are already logged by CollectGarbage
+		_ = internal.CollectGarbage(ctx, runner, nodes, manager)
+	}
+	return nil
 }
 
-func run(ctx context.Context, worker Worker) error {
-	gha := githubactions.New()
-	manager, err := newManager(ctx, gha)
+func run(cmd *cobra.Command, worker Worker) error {
+	ctx := cmd.Context()
+	runner, err := newCIFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	manager, err := newManager(ctx, runner)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := connectBuildkit(ctx, runner)
 	if err != nil {
 		return err
 	}
 
-	gha.Infof("Connecting to docker...")
+	return worker(ctx, runner, nodes, manager)
+}
+
+func connectBuildkit(ctx context.Context, runner ci.CI) ([]buildkit.Node, error) {
+	builderName := runner.GetInput(inputBuildxName)
+
+	driverName := runner.GetInput(inputDriver)
+	if driverName == "" {
+		driverName = buildkit.DetectDriverName(builderName)
+	}
+
+	switch driverName {
+	case buildkit.DriverDockerContainer:
+		docker, err := connectDocker(ctx, runner)
+		if err != nil {
+			return nil, err
+		}
+
+		runner.Infof("Connecting to buildkit daemon...")
+		nodes, err := buildkit.NewContainerizedNodes(ctx, docker, builderName)
+		if err != nil {
+			runner.Errorf("Failed to connect buildkit: %+v", err)
+			return nil, err
+		}
+		return nodes, nil
+
+	case buildkit.DriverKubernetes:
+		runner.Infof("Connecting to buildkit daemon on kubernetes...")
+		nodes, err := buildkit.NewKubernetesNodes(ctx, builderName)
+		if err != nil {
+			runner.Errorf("Failed to connect buildkit: %+v", err)
+			return nil, err
+		}
+		return nodes, nil
+
+	case buildkit.DriverRemote:
+		runner.Infof("Connecting to remote buildkit daemon...")
+		nodes, err := buildkit.NewRemoteNodes(ctx, builderName, buildkit.RemoteOptions{
+			Endpoint:      runner.GetInput(inputBuildkitdEndpoint),
+			TLSServerName: runner.GetInput(inputBuildkitdTLSServerName),
+			TLSCACert:     runner.GetInput(inputBuildkitdTLSCACert),
+			TLSCert:       runner.GetInput(inputBuildkitdTLSCert),
+			TLSKey:        runner.GetInput(inputBuildkitdTLSKey),
+			StopCommand:   runner.GetInput(inputBuildkitdStopCommand),
+			StartCommand:  runner.GetInput(inputBuildkitdStartCommand),
+		})
+		if err != nil {
+			runner.Errorf("Failed to connect buildkit: %+v", err)
+			return nil, err
+		}
+		return nodes, nil
+
+	default:
+		err := errors.Errorf(
+			"unsupported driver: %v. Only supports `%s`, `%s` or `%s`",
+			driverName,
+			buildkit.DriverDockerContainer,
+			buildkit.DriverKubernetes,
+			buildkit.DriverRemote,
+		)
+		runner.Errorf(err.Error())
+		return nil, err
+	}
+}
+
+func connectDocker(ctx context.Context, runner ci.CI) (*client.Client, error) {
+	runner.Infof("Connecting to docker...")
+	dockerEndpoint := runner.GetInput(inputDockerEndpoint)
+	if dockerEndpoint == "" {
+		dockerEndpoint = client.DefaultDockerHost
+	}
 	docker, err := client.NewClientWithOpts(
 		client.FromEnv,
 		client.WithAPIVersionNegotiation(),
 		client.WithHost(dockerEndpoint),
 	)
 	if err != nil {
-		gha.Errorf("Failed connect docker: %+v", err)
-		return err
+		runner.Errorf("Failed connect docker: %+v", err)
+		return nil, err
 	}
 
-	if gha.Getenv("RUNNER_DEBUG") == "1" {
-		gha.Infof("Printing docker info...")
+	if runner.IsDebug() {
+		runner.Infof("Printing docker info...")
 		info, err := docker.Info(ctx)
 		if err != nil {
-			gha.Errorf("Failed info docker: %+v", err)
-			return err
+			runner.Errorf("Failed info docker: %+v", err)
+			return nil, err
 		}
 		escape, err := json.MarshalIndentWithOption(info, "", "    ", json.DisableHTMLEscape())
 		if err != nil {
 			err = errors.WithStack(err)
-			gha.Errorf("Failed marshal docker info into json: %+v", err)
-			return err
+			runner.Errorf("Failed marshal docker info into json: %+v", err)
+			return nil, err
 		}
-		gha.Infof("%+s", string(escape))
+		runner.Infof("%+s", string(escape))
 	}
 
-	gha.Infof("Connecting to buildkit daemon...")
-	builderName := gha.GetInput(inputBuildxName)
-	bkCli, err := buildkit.NewContainerizedDriver(ctx, docker, builderName)
+	return docker, nil
+}
+
+type Worker func(context.Context, ci.CI, []buildkit.Node, remote.Manager) error
+
+func newCIFromFlags(cmd *cobra.Command) (ci.CI, error) {
+	v, err := loadConfig(cmd.Flags())
 	if err != nil {
-		gha.Errorf("Failed to connect buildkit: %+v", err)
-		return err
+		return nil, err
 	}
-
-	return worker(ctx, gha, bkCli, manager)
+	return newCI(v, cmd.Flags()), nil
 }
-
-type Worker func(context.Context, *githubactions.Action, buildkit.Driver, remote.Manager) error
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/isac322/buildkit-state/probe/internal"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagExportFile = "output"
+	flagImportFile = "input"
+)
+
+var (
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Args:  cobra.NoArgs,
+		Short: "Extract buildkit state into a file without remote",
+		RunE:  export,
+	}
+	importCmd = &cobra.Command{
+		Use:   "import",
+		Args:  cobra.NoArgs,
+		Short: "Inject buildkit state from a file that is exported",
+		RunE:  importState,
+	}
+)
+
+func init() {
+	exportCmd.Flags().String(
+		flagExportFile,
+		"",
+		"file to export into (e.g. state.tar.zst), and its metadata goes into <file>.json",
+	)
+	_ = exportCmd.MarkFlagRequired(flagExportFile)
+	importCmd.Flags().String(flagImportFile, "", "file to import from, with its metadata in <file>.json")
+	_ = importCmd.MarkFlagRequired(flagImportFile)
+}
+
+func export(cmd *cobra.Command, _ []string) error {
+	path, err := cmd.Flags().GetString(flagExportFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ctx := cmd.Context()
+	runner, err := newCIFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	nodes, err := connectBuildkit(ctx, runner)
+	if err != nil {
+		return err
+	}
+
+	return internal.ExportToFile(ctx, runner, nodes, path)
+}
+
+func importState(cmd *cobra.Command, _ []string) error {
+	path, err := cmd.Flags().GetString(flagImportFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ctx := cmd.Context()
+	runner, err := newCIFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	nodes, err := connectBuildkit(ctx, runner)
+	if err != nil {
+		return err
+	}
+
+	return internal.ImportFromFile(ctx, runner, nodes, path)
+}
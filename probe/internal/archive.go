@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// archiveRoot is the name of top directory in stored archives.
+// Archives are rebased onto it regardless of the state directory of buildkitd,
+// so states are interchangeable between rootful, rootless and custom `--root` builders.
+var archiveRoot = path.Base(buildkit.DefaultStateDir)
+
+// rebaseArchive renames the top directory of tar stream from `from` to `to`.
+func rebaseArchive(contents io.ReadCloser, from, to string) io.ReadCloser {
+	if from == to {
+		return contents
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		err := copyRebased(tar.NewWriter(pipeWriter), tar.NewReader(contents), from, to)
+		if closeErr := contents.Close(); closeErr != nil {
+			err = errors.Join(err, pkgerrors.WithStack(closeErr))
+		}
+		_ = pipeWriter.CloseWithError(err)
+	}()
+
+	return pipeReader
+}
+
+func copyRebased(writer *tar.Writer, reader *tar.Reader, from, to string) error {
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return pkgerrors.WithStack(err)
+		}
+
+		header.Name = rebasePath(header.Name, from, to)
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = rebasePath(header.Linkname, from, to)
+		}
+
+		if err = writer.WriteHeader(header); err != nil {
+			return pkgerrors.WithStack(err)
+		}
+		if _, err = io.Copy(writer, reader); err != nil {
+			return pkgerrors.WithStack(err)
+		}
+	}
+
+	return pkgerrors.WithStack(writer.Close())
+}
+
+func rebasePath(name, from, to string) string {
+	if name == from {
+		return to
+	}
+	if rest, found := strings.CutPrefix(name, from+"/"); found {
+		return to + "/" + rest
+	}
+	return name
+}
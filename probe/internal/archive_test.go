@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebaseArchive(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	writer := tar.NewWriter(buf)
+	for _, header := range []*tar.Header{
+		{Name: "root", Typeflag: tar.TypeDir, Mode: 0o700},
+		{Name: "root/cache.db", Typeflag: tar.TypeReg, Mode: 0o600, Size: 5},
+		{Name: "root/link.db", Typeflag: tar.TypeLink, Linkname: "root/cache.db"},
+		{Name: "root/symlink.db", Typeflag: tar.TypeSymlink, Linkname: "root/cache.db"},
+		{Name: "rootless", Typeflag: tar.TypeDir, Mode: 0o700},
+	} {
+		require.NoError(t, writer.WriteHeader(header))
+		if header.Size > 0 {
+			_, err := writer.Write([]byte("cache"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, writer.Close())
+
+	rebased := rebaseArchive(io.NopCloser(buf), "root", "buildkit")
+	defer rebased.Close()
+
+	reader := tar.NewReader(rebased)
+	var names, linknames []string
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		linknames = append(linknames, header.Linkname)
+
+		if header.Typeflag == tar.TypeReg {
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("cache"), data)
+		}
+	}
+
+	assert.Equal(
+		t,
+		[]string{"buildkit", "buildkit/cache.db", "buildkit/link.db", "buildkit/symlink.db", "rootless"},
+		names,
+	)
+	// symbolic links are relative to the filesystem, not to the archive
+	assert.Equal(t, []string{"", "", "buildkit/cache.db", "root/cache.db", ""}, linknames)
+}
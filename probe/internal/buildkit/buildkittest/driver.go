@@ -0,0 +1,202 @@
+// Package buildkittest provides a fake buildkit.Driver for tests of code that depends on buildkitd.
+package buildkittest
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+
+	"github.com/docker/docker/pkg/archive"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Names of methods of buildkit.Driver, which are recorded in Driver.Calls and used as keys of Driver.Errors.
+const (
+	MethodStop           = "Stop"
+	MethodResume         = "Resume"
+	MethodPruneExcept    = "PruneExcept"
+	MethodPrintDiskUsage = "PrintDiskUsage"
+	MethodCopyFrom       = "CopyFrom"
+	MethodCopyTo         = "CopyTo"
+	MethodStateDir       = "StateDir"
+	MethodShell          = "Shell"
+	MethodDaemonInfo     = "DaemonInfo"
+)
+
+var errNotRunning = pkgerrors.New("buildkitd is not running")
+
+// Driver pretends buildkitd whose state directory is a directory of the local filesystem, like the remote driver.
+// It is not safe for concurrent use.
+type Driver struct {
+	// Root is the state directory, which is returned by StateDir.
+	Root string
+	Info buildkit.DaemonInfo
+	// Running is whether buildkitd is running. Stop and Resume toggle it.
+	Running bool
+	// Errors makes the method of the key fail with the value, after the call is recorded.
+	Errors map[string]error
+
+	// Calls are names of called methods in order.
+	Calls []string
+	// Pruned are whitelists given to PruneExcept in order.
+	Pruned [][]string
+}
+
+// NewDriver creates running buildkitd whose state directory is a new temporary directory.
+func NewDriver(t testing.TB, info buildkit.DaemonInfo) *Driver {
+	t.Helper()
+
+	return &Driver{
+		Root:    filepath.Join(t.TempDir(), "buildkit"),
+		Info:    info,
+		Running: true,
+		Errors:  make(map[string]error),
+	}
+}
+
+// Called returns the number of calls of method.
+func (d *Driver) Called(method string) int {
+	count := 0
+	for _, call := range d.Calls {
+		if call == method {
+			count++
+		}
+	}
+	return count
+}
+
+func (d *Driver) call(method string) error {
+	d.Calls = append(d.Calls, method)
+	return d.Errors[method]
+}
+
+func (d *Driver) Stop(context.Context) error {
+	if err := d.call(MethodStop); err != nil {
+		return err
+	}
+	d.Running = false
+	return nil
+}
+
+func (d *Driver) Resume(context.Context) error {
+	if err := d.call(MethodResume); err != nil {
+		return err
+	}
+	d.Running = true
+	return nil
+}
+
+func (d *Driver) PruneExcept(_ context.Context, whitelist []string) error {
+	if err := d.call(MethodPruneExcept); err != nil {
+		return err
+	}
+	if !d.Running {
+		return errNotRunning
+	}
+	d.Pruned = append(d.Pruned, whitelist)
+	return nil
+}
+
+func (d *Driver) PrintDiskUsage(context.Context) ([]byte, error) {
+	if err := d.call(MethodPrintDiskUsage); err != nil {
+		return nil, err
+	}
+	if !d.Running {
+		return nil, errNotRunning
+	}
+	return []byte("Total: 0B"), nil
+}
+
+func (d *Driver) CopyFrom(_ context.Context, path string) (io.ReadCloser, int64, error) {
+	if err := d.call(MethodCopyFrom); err != nil {
+		return nil, 0, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, 0, pkgerrors.WithStack(err)
+	}
+
+	contents, err := archive.TarWithOptions(filepath.Dir(path), &archive.TarOptions{
+		IncludeFiles: []string{filepath.Base(path)},
+	})
+	if err != nil {
+		return nil, 0, pkgerrors.WithStack(err)
+	}
+	return contents, -1, nil
+}
+
+func (d *Driver) CopyTo(_ context.Context, path string, content io.Reader) error {
+	if err := d.call(MethodCopyTo); err != nil {
+		return err
+	}
+	return pkgerrors.WithStack(archive.Untar(content, path, &archive.TarOptions{NoLchown: true}))
+}
+
+func (d *Driver) StateDir(context.Context) (string, error) {
+	if err := d.call(MethodStateDir); err != nil {
+		return "", err
+	}
+	return d.Root, nil
+}
+
+func (d *Driver) Shell(ctx context.Context, script string) error {
+	if err := d.call(MethodShell); err != nil {
+		return err
+	}
+	output, err := exec.CommandContext(ctx, "sh", "-c", script).CombinedOutput()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to run `%s`: %s", script, output)
+	}
+	return nil
+}
+
+func (d *Driver) DaemonInfo(context.Context) (buildkit.DaemonInfo, error) {
+	if err := d.call(MethodDaemonInfo); err != nil {
+		return buildkit.DaemonInfo{}, err
+	}
+	if !d.Running {
+		return buildkit.DaemonInfo{}, errNotRunning
+	}
+	return d.Info, nil
+}
+
+// WriteState replaces the state directory with files, whose keys are paths relative to the state directory.
+func (d *Driver) WriteState(files map[string]string) error {
+	if err := os.RemoveAll(d.Root); err != nil {
+		return pkgerrors.WithStack(err)
+	}
+	for name, content := range files {
+		fullPath := filepath.Join(d.Root, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o700); err != nil {
+			return pkgerrors.WithStack(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0o600); err != nil {
+			return pkgerrors.WithStack(err)
+		}
+	}
+	return pkgerrors.WithStack(os.MkdirAll(d.Root, 0o700))
+}
+
+// ReadState returns files in the state directory, keyed by paths relative to the state directory.
+func (d *Driver) ReadState() (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(d.Root, func(fullPath string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(fullPath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(d.Root, fullPath)
+		files[rel] = string(content)
+		return err
+	})
+	return files, pkgerrors.WithStack(err)
+}
+
+var _ buildkit.Driver = (*Driver)(nil)
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"text/tabwriter"
 
+	"github.com/containerd/containerd/platforms"
 	bkclient "github.com/moby/buildkit/client"
 	pkgerrors "github.com/pkg/errors"
 	"github.com/tonistiigi/units"
@@ -31,6 +32,14 @@ func newCommonDriver(bkClient *bkclient.Client) commonDriver {
 	return commonDriver{bkClient: bkClient}
 }
 
+// replaceClient closes the client of the previous buildkitd, which is useless after restart, and uses bkClient.
+func (d *commonDriver) replaceClient(bkClient *bkclient.Client) {
+	if d.bkClient != nil {
+		_ = d.bkClient.Close()
+	}
+	d.bkClient = bkClient
+}
+
 func (d *commonDriver) PruneExcept(ctx context.Context, whitelist []string) error {
 	filters := make([]string, 0, len(pruneTypes))
 	for _, recordType := range pruneTypes {
@@ -120,3 +129,38 @@ func printSummary(tw *tabwriter.Writer, du []*bkclient.UsageInfo) {
 	fmt.Fprintf(tw, "Total:\t%.2f\n", units.Bytes(total))
 	tw.Flush()
 }
+
+// labelSnapshotter is the worker label that buildkitd reports its snapshotter with.
+const labelSnapshotter = "org.mobyproject.buildkit.worker.snapshotter"
+
+// DaemonInfo describes what the state of buildkitd depends on.
+type DaemonInfo struct {
+	Version     string
+	Snapshotter string
+	Platform    string
+}
+
+func (d *commonDriver) DaemonInfo(ctx context.Context) (DaemonInfo, error) {
+	info, err := d.bkClient.Info(ctx)
+	if err != nil {
+		return DaemonInfo{}, pkgerrors.WithStack(err)
+	}
+
+	workers, err := d.bkClient.ListWorkers(ctx)
+	if err != nil {
+		return DaemonInfo{}, pkgerrors.WithStack(err)
+	}
+	if len(workers) == 0 {
+		return DaemonInfo{}, pkgerrors.New("buildkitd has no worker")
+	}
+
+	// buildx creates buildkitd with a single worker
+	result := DaemonInfo{
+		Version:     info.BuildkitVersion.Version,
+		Snapshotter: workers[0].Labels[labelSnapshotter],
+	}
+	if len(workers[0].Platforms) > 0 {
+		result.Platform = platforms.Format(workers[0].Platforms[0])
+	}
+	return result, nil
+}
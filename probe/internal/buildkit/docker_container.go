@@ -5,19 +5,27 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	bkclient "github.com/moby/buildkit/client"
 	pkgerrors "github.com/pkg/errors"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/sync/errgroup"
 )
 
+const containerNamePrefix = "buildx_buildkit_"
+
 type dockerContainer struct {
 	docker        client.CommonAPIClient
 	builderName   string
@@ -25,8 +33,70 @@ type dockerContainer struct {
 	commonDriver
 }
 
-func NewContainerizedDriver(ctx context.Context, docker client.CommonAPIClient, builderName string) (Driver, error) {
-	containerName := fmt.Sprintf("buildx_buildkit_%s0", builderName)
+// NewContainerizedNodes creates drivers for every node of buildx builder which uses docker-container driver.
+func NewContainerizedNodes(ctx context.Context, docker client.CommonAPIClient, builderName string) ([]Node, error) {
+	nodeNames, err := readBuildxNodeNames(builderName)
+	if err != nil || len(nodeNames) == 0 {
+		nodeNames, err = listContainerizedNodeNames(ctx, docker, builderName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	drivers := make([]Driver, 0, len(nodeNames))
+	for _, nodeName := range nodeNames {
+		driver, err := newContainerizedDriver(ctx, docker, builderName, containerNamePrefix+nodeName)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, driver)
+	}
+
+	return newNodes(ctx, drivers)
+}
+
+// listContainerizedNodeNames finds nodes by the name of containers,
+// which works only for nodes that have default name (`<builder name><index>`).
+func listContainerizedNodeNames(
+	ctx context.Context,
+	docker client.CommonAPIClient,
+	builderName string,
+) ([]string, error) {
+	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("name", containerNamePrefix+builderName)),
+	})
+	if err != nil {
+		return nil, pkgerrors.WithStack(err)
+	}
+
+	pattern := regexp.MustCompile("^/" + regexp.QuoteMeta(containerNamePrefix+builderName) + `(\d+)$`)
+	indexes := make(map[string]int)
+	for _, c := range containers {
+		for _, name := range c.Names {
+			if matches := pattern.FindStringSubmatch(name); matches != nil {
+				index, _ := strconv.Atoi(matches[1])
+				indexes[builderName+matches[1]] = index
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		// let later operation report error of missing container
+		return []string{builderName + "0"}, nil
+	}
+
+	nodeNames := maps.Keys(indexes)
+	slices.SortFunc(nodeNames, func(a, b string) bool {
+		return indexes[a] < indexes[b]
+	})
+	return nodeNames, nil
+}
+
+func newContainerizedDriver(
+	ctx context.Context,
+	docker client.CommonAPIClient,
+	builderName, containerName string,
+) (*dockerContainer, error) {
 	bkcli, err := bkclient.New(
 		ctx,
 		"",
@@ -63,7 +133,7 @@ func (d *dockerContainer) Resume(ctx context.Context) error {
 		return err
 	}
 
-	d.commonDriver.bkClient = bkcli
+	d.replaceClient(bkcli)
 	return nil
 }
 
@@ -113,6 +183,94 @@ func (d *dockerContainer) CopyTo(ctx context.Context, path string, content io.Re
 	)
 }
 
+func (d *dockerContainer) StateDir(ctx context.Context) (string, error) {
+	info, err := d.docker.ContainerInspect(ctx, d.containerName)
+	if err != nil {
+		return "", pkgerrors.WithStack(err)
+	}
+	return stateDirOf(info), nil
+}
+
+func stateDirOf(info types.ContainerJSON) string {
+	if stateDir, found := stateDirFromArgs(info.Args); found {
+		return stateDir
+	}
+	if info.Config != nil && !isRootUser(info.Config.User) {
+		return RootlessStateDir
+	}
+	return DefaultStateDir
+}
+
+// Shell runs script in a temporary container that shares volumes with the stopped buildkitd container,
+// because stopped container can not execute anything.
+// So the state directory has to be on a volume, which is the default of buildx.
+func (d *dockerContainer) Shell(ctx context.Context, script string) error {
+	info, err := d.docker.ContainerInspect(ctx, d.containerName)
+	if err != nil {
+		return pkgerrors.WithStack(err)
+	}
+	stateDir := stateDirOf(info)
+	onVolume := slices.ContainsFunc(info.Mounts, func(mount types.MountPoint) bool {
+		rel, err := filepath.Rel(mount.Destination, stateDir)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+	})
+	if !onVolume {
+		return pkgerrors.Errorf("state directory %s of %s is not on a volume", stateDir, d.containerName)
+	}
+
+	config := &container.Config{
+		Image:      info.Image,
+		Entrypoint: []string{"sh", "-c"},
+		Cmd:        []string{script},
+	}
+	if info.Config != nil {
+		config.User = info.Config.User
+	}
+	helper, err := d.docker.ContainerCreate(
+		ctx,
+		config,
+		&container.HostConfig{VolumesFrom: []string{d.containerName}},
+		nil,
+		nil,
+		"",
+	)
+	if err != nil {
+		return pkgerrors.WithStack(err)
+	}
+	defer func() {
+		_ = d.docker.ContainerRemove(ctx, helper.ID, types.ContainerRemoveOptions{Force: true})
+	}()
+
+	statusCh, errCh := d.docker.ContainerWait(ctx, helper.ID, container.WaitConditionNextExit)
+	if err = d.docker.ContainerStart(ctx, helper.ID, types.ContainerStartOptions{}); err != nil {
+		return pkgerrors.WithStack(err)
+	}
+
+	select {
+	case err = <-errCh:
+		return pkgerrors.WithStack(err)
+	case status := <-statusCh:
+		if status.StatusCode == 0 {
+			return nil
+		}
+		return pkgerrors.Errorf("failed to run `%s`: %s", script, d.readLogs(ctx, helper.ID))
+	}
+}
+
+func (d *dockerContainer) readLogs(ctx context.Context, containerID string) string {
+	logs, err := d.docker.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return err.Error()
+	}
+	defer logs.Close()
+
+	output := new(bytes.Buffer)
+	if _, err = stdcopy.StdCopy(output, output, logs); err != nil {
+		return err.Error()
+	}
+	return output.String()
+}
+
 type hijackedNetConn struct {
 	net.Conn
 	errGrp        *errgroup.Group
@@ -5,6 +5,13 @@ import (
 	"io"
 )
 
+// Names of buildx drivers.
+const (
+	DriverDockerContainer = "docker-container"
+	DriverKubernetes      = "kubernetes"
+	DriverRemote          = "remote"
+)
+
 type Driver interface {
 	Stop(ctx context.Context) error
 	Resume(ctx context.Context) error
@@ -12,4 +19,10 @@ type Driver interface {
 	PrintDiskUsage(ctx context.Context) ([]byte, error)
 	CopyFrom(ctx context.Context, path string) (io.ReadCloser, int64, error)
 	CopyTo(ctx context.Context, path string, content io.Reader) error
+	// StateDir detects the state directory (`--root`) of buildkitd.
+	StateDir(ctx context.Context) (string, error)
+	// Shell runs script by `sh` with access to the filesystem of stopped buildkitd.
+	Shell(ctx context.Context, script string) error
+	// DaemonInfo queries running buildkitd, so it has to be called before Stop.
+	DaemonInfo(ctx context.Context) (DaemonInfo, error)
 }
@@ -0,0 +1,664 @@
+package buildkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	bkclient "github.com/moby/buildkit/client"
+	pkgerrors "github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	kubernetesBuildkitdContainer = "buildkitd"
+	kubernetesHelperContainer    = "state"
+	kubernetesHelperSuffix       = "-buildkit-state"
+	kubernetesManagedByLabel     = "app.kubernetes.io/managed-by"
+	kubernetesManagedByValue     = "buildkit-state"
+	kubernetesPollInterval       = time.Second
+)
+
+// podExecutor runs command in a container of pod, just like `kubectl exec`.
+type podExecutor interface {
+	Exec(
+		ctx context.Context,
+		namespace, pod, container string,
+		cmd []string,
+		stdin io.Reader,
+		stdout, stderr io.Writer,
+	) error
+}
+
+// kubernetesPod is a node of buildx builder which uses kubernetes driver.
+//
+// buildkitd of the kubernetes driver is PID 1 of the pod, so it can not be stopped without the pod.
+// Instead, Stop scales the deployment down to zero
+// and starts a helper pod which mounts the same volumes to access the state directory while buildkitd is down.
+// Therefore, the state directory must be backed by a persistent volume to outlive buildkitd pods.
+type kubernetesPod struct {
+	clientset  kubernetes.Interface
+	executor   podExecutor
+	namespace  string
+	deployment string
+	replicas   int32
+	template   *corev1.PodSpec
+	commonDriver
+}
+
+// NewKubernetesNodes creates drivers for every node of buildx builder which uses kubernetes driver.
+func NewKubernetesNodes(ctx context.Context, builderName string) ([]Node, error) {
+	instance, err := readBuildxInstance(builderName)
+	if err != nil {
+		return nil, err
+	}
+
+	drivers := make([]Driver, 0, len(instance.Nodes))
+	for _, node := range instance.Nodes {
+		deployment, kubeconfig := parseKubernetesEndpoint(node)
+
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = kubeconfig
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			loadingRules,
+			&clientcmd.ConfigOverrides{},
+		)
+
+		restConfig, err := clientConfig.ClientConfig()
+		if err != nil {
+			return nil, pkgerrors.WithStack(err)
+		}
+		namespace := node.DriverOpts["namespace"]
+		if namespace == "" {
+			if namespace, _, err = clientConfig.Namespace(); err != nil {
+				return nil, pkgerrors.WithStack(err)
+			}
+		}
+
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, pkgerrors.WithStack(err)
+		}
+
+		driver, err := newKubernetesDriver(
+			ctx,
+			clientset,
+			&spdyExecutor{restConfig, clientset},
+			namespace,
+			deployment,
+		)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, driver)
+	}
+
+	return newNodes(ctx, drivers)
+}
+
+// parseKubernetesEndpoint extracts the deployment name and kubeconfig path
+// from endpoint of the node that buildx stores (e.g. `kubernetes:///builder?deployment=builder0&kubeconfig=`).
+func parseKubernetesEndpoint(node buildxNode) (deployment, kubeconfig string) {
+	deployment = node.Name
+	endpoint, err := url.Parse(node.Endpoint)
+	if err != nil || endpoint.Scheme != DriverKubernetes {
+		return deployment, ""
+	}
+
+	query := endpoint.Query()
+	if d := query.Get("deployment"); d != "" {
+		deployment = d
+	}
+	return deployment, query.Get("kubeconfig")
+}
+
+func newKubernetesDriver(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	executor podExecutor,
+	namespace, deployment string,
+) (*kubernetesPod, error) {
+	d := &kubernetesPod{
+		clientset:  clientset,
+		executor:   executor,
+		namespace:  namespace,
+		deployment: deployment,
+		replicas:   1,
+	}
+
+	bkcli, err := bkclient.New(ctx, "", bkclient.WithContextDialer(d.dial))
+	if err != nil {
+		return nil, err
+	}
+	d.commonDriver = newCommonDriver(bkcli)
+
+	return d, nil
+}
+
+func (d *kubernetesPod) helperPodName() string {
+	return d.deployment + kubernetesHelperSuffix
+}
+
+func (d *kubernetesPod) Stop(ctx context.Context) error {
+	deploy, err := d.clientset.AppsV1().Deployments(d.namespace).Get(ctx, d.deployment, metav1.GetOptions{})
+	if err != nil {
+		return pkgerrors.WithStack(err)
+	}
+	if deploy.Spec.Replicas != nil && *deploy.Spec.Replicas > 0 {
+		d.replicas = *deploy.Spec.Replicas
+	}
+	// scaling down a builder without persistent state loses it, so it is rejected before anything is changed
+	stateDir, err := d.stateDirOf(deploy.Spec.Template.Spec)
+	if err != nil {
+		return err
+	}
+	if err = d.checkPersistentIn(deploy.Spec.Template.Spec, stateDir); err != nil {
+		return err
+	}
+	d.template = deploy.Spec.Template.Spec.DeepCopy()
+
+	if err = d.scale(ctx, 0); err != nil {
+		return err
+	}
+	err = d.waitPods(ctx, func(pods []corev1.Pod) (bool, error) {
+		return len(pods) == 0, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	helper, err := newHelperPod(d.helperPodName(), deploy)
+	if err != nil {
+		return err
+	}
+	_, err = d.clientset.CoreV1().Pods(d.namespace).Create(ctx, helper, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return pkgerrors.WithStack(err)
+	}
+
+	return pkgerrors.WithStack(wait.PollUntilContextCancel(
+		ctx,
+		kubernetesPollInterval,
+		true,
+		func(ctx context.Context) (bool, error) {
+			pod, err := d.clientset.CoreV1().Pods(d.namespace).Get(ctx, helper.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			switch pod.Status.Phase {
+			case corev1.PodRunning:
+				return true, nil
+			case corev1.PodFailed, corev1.PodSucceeded:
+				return false, pkgerrors.Errorf("helper pod %s is terminated unexpectedly", helper.Name)
+			default:
+				return false, nil
+			}
+		},
+	))
+}
+
+func (d *kubernetesPod) Resume(ctx context.Context) error {
+	err := d.clientset.CoreV1().Pods(d.namespace).Delete(ctx, d.helperPodName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return pkgerrors.WithStack(err)
+	}
+	// volumes that can be attached to only one node at a time (e.g. ReadWriteOnce) have to be released first
+	err = d.waitPodGone(ctx, d.helperPodName())
+	if err != nil {
+		return err
+	}
+
+	if err = d.scale(ctx, d.replicas); err != nil {
+		return err
+	}
+	err = d.waitPods(ctx, func(pods []corev1.Pod) (bool, error) {
+		for _, pod := range pods {
+			if isPodReady(pod) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bkcli, err := bkclient.New(ctx, "", bkclient.WithContextDialer(d.dial))
+	if err != nil {
+		return err
+	}
+
+	d.replaceClient(bkcli)
+	return nil
+}
+
+func (d *kubernetesPod) scale(ctx context.Context, replicas int32) error {
+	patch, err := json.Marshal(map[string]any{"spec": map[string]any{"replicas": replicas}})
+	if err != nil {
+		return pkgerrors.WithStack(err)
+	}
+
+	_, err = d.clientset.AppsV1().Deployments(d.namespace).Patch(
+		ctx,
+		d.deployment,
+		types.MergePatchType,
+		patch,
+		metav1.PatchOptions{},
+	)
+	return pkgerrors.WithStack(err)
+}
+
+// waitPods polls pods of the deployment until condition is satisfied.
+func (d *kubernetesPod) waitPods(ctx context.Context, condition func([]corev1.Pod) (bool, error)) error {
+	return pkgerrors.WithStack(wait.PollUntilContextCancel(
+		ctx,
+		kubernetesPollInterval,
+		true,
+		func(ctx context.Context) (bool, error) {
+			pods, err := d.listPods(ctx)
+			if err != nil {
+				return false, err
+			}
+			return condition(pods)
+		},
+	))
+}
+
+func (d *kubernetesPod) waitPodGone(ctx context.Context, name string) error {
+	return pkgerrors.WithStack(wait.PollUntilContextCancel(
+		ctx,
+		kubernetesPollInterval,
+		true,
+		func(ctx context.Context) (bool, error) {
+			_, err := d.clientset.CoreV1().Pods(d.namespace).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		},
+	))
+}
+
+func (d *kubernetesPod) listPods(ctx context.Context) ([]corev1.Pod, error) {
+	deploy, err := d.clientset.AppsV1().Deployments(d.namespace).Get(ctx, d.deployment, metav1.GetOptions{})
+	if err != nil {
+		return nil, pkgerrors.WithStack(err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+	if err != nil {
+		return nil, pkgerrors.WithStack(err)
+	}
+
+	pods, err := d.clientset.CoreV1().Pods(d.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, pkgerrors.WithStack(err)
+	}
+
+	alive := make([]corev1.Pod, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		if pod.Labels[kubernetesManagedByLabel] == kubernetesManagedByValue {
+			continue
+		}
+		alive = append(alive, pod)
+	}
+	return alive, nil
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func findBuildkitdContainer(containers []corev1.Container) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == kubernetesBuildkitdContainer {
+			return &containers[i]
+		}
+	}
+	if len(containers) > 0 {
+		return &containers[0]
+	}
+	return nil
+}
+
+// newHelperPod creates a pod that mounts volumes of buildkitd container with the same image and security context,
+// so the files in the state directory keep their ownership.
+func newHelperPod(name string, deploy *appsv1.Deployment) (*corev1.Pod, error) {
+	spec := deploy.Spec.Template.Spec.DeepCopy()
+	buildkitd := findBuildkitdContainer(spec.Containers)
+	if buildkitd == nil {
+		return nil, pkgerrors.Errorf("no container found from deployment %s", deploy.Name)
+	}
+
+	spec.InitContainers = nil
+	spec.Containers = []corev1.Container{{
+		Name:            kubernetesHelperContainer,
+		Image:           buildkitd.Image,
+		ImagePullPolicy: buildkitd.ImagePullPolicy,
+		Command:         []string{"tail", "-f", "/dev/null"},
+		VolumeMounts:    buildkitd.VolumeMounts,
+		SecurityContext: buildkitd.SecurityContext,
+	}}
+	spec.RestartPolicy = corev1.RestartPolicyNever
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: deploy.Namespace,
+			// must not match the selector of deployment, otherwise the replica set adopts it
+			Labels: map[string]string{kubernetesManagedByLabel: kubernetesManagedByValue},
+		},
+		Spec: *spec,
+	}, nil
+}
+
+// checkPersistent checks whether the target is in a persistent volume of buildkitd container,
+// because anything else is gone with the pod when the deployment is scaled down.
+func (d *kubernetesPod) checkPersistent(target string) error {
+	if d.template == nil {
+		return pkgerrors.New("buildkitd must be stopped before copying its state")
+	}
+	return d.checkPersistentIn(*d.template, target)
+}
+
+func (d *kubernetesPod) checkPersistentIn(spec corev1.PodSpec, target string) error {
+	buildkitd := findBuildkitdContainer(spec.Containers)
+	if buildkitd == nil {
+		return pkgerrors.Errorf("no container found from deployment %s", d.deployment)
+	}
+	for _, mount := range buildkitd.VolumeMounts {
+		if !isSubPath(mount.MountPath, target) && !isSubPath(target, mount.MountPath) {
+			continue
+		}
+		for _, volume := range spec.Volumes {
+			if volume.Name == mount.Name && volume.EmptyDir == nil {
+				return nil
+			}
+		}
+	}
+
+	return pkgerrors.Errorf(
+		"%s of deployment %s is not backed by a persistent volume, so it can not outlive buildkitd pods "+
+			"(mount a PersistentVolumeClaim at it)",
+		target,
+		d.deployment,
+	)
+}
+
+func isSubPath(parent, child string) bool {
+	parent, child = path.Clean(parent), path.Clean(child)
+	return parent == child || strings.HasPrefix(child, strings.TrimSuffix(parent, "/")+"/")
+}
+
+func (d *kubernetesPod) CopyFrom(ctx context.Context, target string) (io.ReadCloser, int64, error) {
+	if err := d.checkPersistent(target); err != nil {
+		return nil, 0, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		stderr := new(bytes.Buffer)
+		err := d.executor.Exec(
+			ctx,
+			d.namespace,
+			d.helperPodName(),
+			kubernetesHelperContainer,
+			[]string{"tar", "cf", "-", "-C", path.Dir(target), path.Base(target)},
+			nil,
+			writer,
+			stderr,
+		)
+		if err != nil {
+			err = pkgerrors.Wrapf(err, "failed to archive %s: %s", target, stderr.String())
+		}
+		_ = writer.CloseWithError(err)
+	}()
+
+	// size of the directory is unknown without walking it
+	return reader, -1, nil
+}
+
+func (d *kubernetesPod) CopyTo(ctx context.Context, target string, content io.Reader) error {
+	if err := d.checkPersistent(target); err != nil {
+		return err
+	}
+
+	stderr := new(bytes.Buffer)
+	err := d.executor.Exec(
+		ctx,
+		d.namespace,
+		d.helperPodName(),
+		kubernetesHelperContainer,
+		[]string{"tar", "xf", "-", "-C", target},
+		content,
+		io.Discard,
+		stderr,
+	)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to extract into %s: %s", target, stderr.String())
+	}
+	return nil
+}
+
+func (d *kubernetesPod) Shell(ctx context.Context, script string) error {
+	output := new(bytes.Buffer)
+	err := d.executor.Exec(
+		ctx,
+		d.namespace,
+		d.helperPodName(),
+		kubernetesHelperContainer,
+		[]string{"sh", "-c", script},
+		nil,
+		output,
+		output,
+	)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to run `%s`: %s", script, output.String())
+	}
+	return nil
+}
+
+func (d *kubernetesPod) StateDir(ctx context.Context) (string, error) {
+	deploy, err := d.clientset.AppsV1().Deployments(d.namespace).Get(ctx, d.deployment, metav1.GetOptions{})
+	if err != nil {
+		return "", pkgerrors.WithStack(err)
+	}
+	return d.stateDirOf(deploy.Spec.Template.Spec)
+}
+
+func (d *kubernetesPod) stateDirOf(spec corev1.PodSpec) (string, error) {
+	buildkitd := findBuildkitdContainer(spec.Containers)
+	if buildkitd == nil {
+		return "", pkgerrors.Errorf("no container found from deployment %s", d.deployment)
+	}
+
+	if stateDir, found := stateDirFromArgs(append(buildkitd.Command, buildkitd.Args...)); found {
+		return stateDir, nil
+	}
+	if isRootlessPod(spec, *buildkitd) {
+		return RootlessStateDir, nil
+	}
+	return DefaultStateDir, nil
+}
+
+// isRootlessPod guesses whether buildkitd runs in rootless mode,
+// because the user of image is unknown to the kubernetes API.
+func isRootlessPod(spec corev1.PodSpec, buildkitd corev1.Container) bool {
+	if buildkitd.SecurityContext != nil && buildkitd.SecurityContext.RunAsUser != nil {
+		return *buildkitd.SecurityContext.RunAsUser != 0
+	}
+	if spec.SecurityContext != nil && spec.SecurityContext.RunAsUser != nil {
+		return *spec.SecurityContext.RunAsUser != 0
+	}
+	return strings.Contains(buildkitd.Image, "rootless")
+}
+
+// dial connects to buildkitd through `buildctl dial-stdio` of a ready pod of the deployment.
+func (d *kubernetesPod) dial(ctx context.Context, _ string) (net.Conn, error) {
+	pods, err := d.listPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *corev1.Pod
+	for i := range pods {
+		if isPodReady(pods[i]) {
+			target = &pods[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil, pkgerrors.Errorf("no ready pod found from deployment %s", d.deployment)
+	}
+	container := findBuildkitdContainer(target.Spec.Containers)
+	if container == nil {
+		return nil, pkgerrors.Errorf("no container found from pod %s", target.Name)
+	}
+
+	// the connection outlives ctx of dialing
+	connCtx, cancel := context.WithCancel(context.Background())
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	conn := &execConn{
+		Reader: stdoutReader,
+		Writer: stdinWriter,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(conn.done)
+		stderr := new(bytes.Buffer)
+		err := d.executor.Exec(
+			connCtx,
+			d.namespace,
+			target.Name,
+			container.Name,
+			[]string{"buildctl", "dial-stdio"},
+			stdinReader,
+			stdoutWriter,
+			stderr,
+		)
+		if err != nil && stderr.Len() > 0 {
+			err = pkgerrors.Wrapf(err, "failed to dial with stderr: %s", stderr.String())
+		}
+		_ = stdinReader.CloseWithError(err)
+		_ = stdoutWriter.CloseWithError(err)
+	}()
+
+	return conn, nil
+}
+
+// execConn is net.Conn over stdin and stdout of `buildctl dial-stdio`.
+type execConn struct {
+	io.Reader
+	io.Writer
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *execConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		<-c.done
+	})
+	return nil
+}
+
+func (c *execConn) LocalAddr() net.Addr {
+	return execAddr{}
+}
+
+func (c *execConn) RemoteAddr() net.Addr {
+	return execAddr{}
+}
+
+func (c *execConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *execConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *execConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+var _ net.Conn = (*execConn)(nil)
+
+type execAddr struct{}
+
+func (execAddr) Network() string {
+	return "exec"
+}
+
+func (execAddr) String() string {
+	return "buildctl dial-stdio"
+}
+
+// spdyExecutor is podExecutor that uses the exec subresource of pods.
+type spdyExecutor struct {
+	restConfig *rest.Config
+	clientset  kubernetes.Interface
+}
+
+func (e *spdyExecutor) Exec(
+	ctx context.Context,
+	namespace, pod, container string,
+	cmd []string,
+	stdin io.Reader,
+	stdout, stderr io.Writer,
+) error {
+	req := e.clientset.CoreV1().RESTClient().
+		Post().
+		Namespace(namespace).
+		Resource("pods").
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   cmd,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.restConfig, "POST", req.URL())
+	if err != nil {
+		return pkgerrors.WithStack(err)
+	}
+
+	return pkgerrors.WithStack(executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	}))
+}
@@ -0,0 +1,336 @@
+package buildkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testNamespace  = "buildkit"
+	testDeployment = "builder0"
+)
+
+type execCall struct {
+	pod       string
+	container string
+	cmd       []string
+	stdin     []byte
+}
+
+type fakeExecutor struct {
+	mu     sync.Mutex
+	calls  []execCall
+	stdout []byte
+}
+
+func (e *fakeExecutor) Exec(
+	_ context.Context,
+	_, pod, container string,
+	cmd []string,
+	stdin io.Reader,
+	stdout, _ io.Writer,
+) error {
+	call := execCall{pod: pod, container: container, cmd: cmd}
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		call.stdin = data
+	}
+
+	e.mu.Lock()
+	e.calls = append(e.calls, call)
+	e.mu.Unlock()
+
+	_, err := stdout.Write(e.stdout)
+	return err
+}
+
+func newTestDeployment(replicas int32, volume corev1.VolumeSource) *appsv1.Deployment {
+	labels := map[string]string{"app": testDeployment}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: testDeployment, Namespace: testNamespace},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:         kubernetesBuildkitdContainer,
+						Image:        "moby/buildkit:buildx-stable-1",
+						VolumeMounts: []corev1.VolumeMount{{Name: "state", MountPath: "/var/lib/buildkit"}},
+					}},
+					Volumes: []corev1.Volume{{Name: "state", VolumeSource: volume}},
+				},
+			},
+		},
+	}
+}
+
+// newFakeClientset creates clientset that imitates the deployment controller and kubelet:
+// scaling the deployment creates or removes ready pods, and created pods are running immediately.
+func newFakeClientset(t *testing.T, deploy *appsv1.Deployment) *fake.Clientset {
+	t.Helper()
+
+	clientset := fake.NewSimpleClientset(deploy)
+	tracker := clientset.Tracker()
+
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Status.Phase = corev1.PodRunning
+		return false, nil, nil
+	})
+	clientset.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		var patch appsv1.Deployment
+		if err := json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch); err != nil {
+			return true, nil, err
+		}
+
+		podName := testDeployment + "-pod"
+		if *patch.Spec.Replicas == 0 {
+			_ = tracker.Delete(corev1.SchemeGroupVersion.WithResource("pods"), testNamespace, podName)
+			return false, nil, nil
+		}
+
+		err := tracker.Add(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      podName,
+				Namespace: testNamespace,
+				Labels:    deploy.Spec.Template.Labels,
+			},
+			Spec: deploy.Spec.Template.Spec,
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		})
+		return false, nil, err
+	})
+
+	return clientset
+}
+
+func TestKubernetesPod_StopAndResume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deploy := newTestDeployment(2, corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "state"},
+	})
+	clientset := newFakeClientset(t, deploy)
+
+	driver, err := newKubernetesDriver(ctx, clientset, &fakeExecutor{}, testNamespace, testDeployment)
+	require.NoError(t, err)
+
+	require.NoError(t, driver.Stop(ctx))
+
+	stopped, err := clientset.AppsV1().Deployments(testNamespace).Get(ctx, testDeployment, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), *stopped.Spec.Replicas)
+
+	helper, err := clientset.CoreV1().Pods(testNamespace).Get(ctx, driver.helperPodName(), metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, helper.Spec.Containers, 1)
+	assert.Equal(t, deploy.Spec.Template.Spec.Containers[0].Image, helper.Spec.Containers[0].Image)
+	assert.Equal(t, deploy.Spec.Template.Spec.Containers[0].VolumeMounts, helper.Spec.Containers[0].VolumeMounts)
+	assert.Equal(t, deploy.Spec.Template.Spec.Volumes, helper.Spec.Volumes)
+	assert.NotEqual(t, deploy.Spec.Template.Labels, helper.Labels)
+
+	require.NoError(t, driver.Resume(ctx))
+
+	resumed, err := clientset.AppsV1().Deployments(testNamespace).Get(ctx, testDeployment, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), *resumed.Spec.Replicas)
+
+	pods, err := clientset.CoreV1().Pods(testNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, pods.Items, 1)
+	assert.Equal(t, testDeployment+"-pod", pods.Items[0].Name)
+}
+
+func TestKubernetesPod_Copy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deploy := newTestDeployment(1, corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "state"},
+	})
+	executor := &fakeExecutor{stdout: []byte("archive")}
+
+	driver, err := newKubernetesDriver(ctx, newFakeClientset(t, deploy), executor, testNamespace, testDeployment)
+	require.NoError(t, err)
+
+	_, _, err = driver.CopyFrom(ctx, "/var/lib/buildkit")
+	require.Error(t, err, "copy before stop must fail")
+
+	require.NoError(t, driver.Stop(ctx))
+
+	contents, _, err := driver.CopyFrom(ctx, "/var/lib/buildkit")
+	require.NoError(t, err)
+	data, err := io.ReadAll(contents)
+	require.NoError(t, err)
+	require.NoError(t, contents.Close())
+	assert.Equal(t, []byte("archive"), data)
+
+	require.NoError(t, driver.CopyTo(ctx, "/var/lib", bytes.NewReader([]byte("restored"))))
+
+	assert.Equal(
+		t,
+		[]execCall{
+			{
+				pod:       driver.helperPodName(),
+				container: kubernetesHelperContainer,
+				cmd:       []string{"tar", "cf", "-", "-C", "/var/lib", "buildkit"},
+			},
+			{
+				pod:       driver.helperPodName(),
+				container: kubernetesHelperContainer,
+				cmd:       []string{"tar", "xf", "-", "-C", "/var/lib"},
+				stdin:     []byte("restored"),
+			},
+		},
+		executor.calls,
+	)
+}
+
+func TestKubernetesPod_StopWithoutPersistentVolume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deploy := newTestDeployment(1, corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}})
+	clientset := newFakeClientset(t, deploy)
+
+	driver, err := newKubernetesDriver(ctx, clientset, &fakeExecutor{}, testNamespace, testDeployment)
+	require.NoError(t, err)
+
+	err = driver.Stop(ctx)
+	assert.ErrorContains(t, err, "persistent volume")
+
+	unchanged, err := clientset.AppsV1().Deployments(testNamespace).Get(ctx, testDeployment, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), *unchanged.Spec.Replicas, "must not be scaled down")
+	_, err = clientset.CoreV1().Pods(testNamespace).Get(ctx, driver.helperPodName(), metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "helper pod must not be created")
+
+	_, _, err = driver.CopyFrom(ctx, "/var/lib/buildkit")
+	require.Error(t, err)
+	err = driver.CopyTo(ctx, "/var/lib", bytes.NewReader(nil))
+	require.Error(t, err)
+}
+
+func TestParseKubernetesEndpoint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		node               buildxNode
+		expectedDeployment string
+		expectedKubeconfig string
+	}{
+		{
+			name: "endpoint of kubernetes driver",
+			node: buildxNode{
+				Name:     "builder0",
+				Endpoint: "kubernetes:///builder?deployment=dep&kubeconfig=%2Fkube",
+			},
+			expectedDeployment: "dep",
+			expectedKubeconfig: "/kube",
+		},
+		{
+			name:               "without deployment",
+			node:               buildxNode{Name: "builder0", Endpoint: "kubernetes:///builder"},
+			expectedDeployment: "builder0",
+			expectedKubeconfig: "",
+		},
+		{
+			name:               "endpoint of other driver",
+			node:               buildxNode{Name: "builder0", Endpoint: "unix:///var/run/docker.sock"},
+			expectedDeployment: "builder0",
+			expectedKubeconfig: "",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			deployment, kubeconfig := parseKubernetesEndpoint(tt.node)
+			assert.Equal(t, tt.expectedDeployment, deployment)
+			assert.Equal(t, tt.expectedKubeconfig, kubeconfig)
+		})
+	}
+}
+
+func TestKubernetesPod_StateDir(t *testing.T) {
+	t.Parallel()
+
+	rootless := int64(1000)
+	tests := []struct {
+		name             string
+		modify           func(container *corev1.Container)
+		expectedStateDir string
+	}{
+		{
+			name:             "default",
+			modify:           func(*corev1.Container) {},
+			expectedStateDir: DefaultStateDir,
+		},
+		{
+			name: "root flag",
+			modify: func(container *corev1.Container) {
+				container.Args = []string{"--root", "/data/buildkit"}
+			},
+			expectedStateDir: "/data/buildkit",
+		},
+		{
+			name: "rootless image",
+			modify: func(container *corev1.Container) {
+				container.Image = "moby/buildkit:buildx-stable-1-rootless"
+			},
+			expectedStateDir: RootlessStateDir,
+		},
+		{
+			name: "non-root user",
+			modify: func(container *corev1.Container) {
+				container.SecurityContext = &corev1.SecurityContext{RunAsUser: &rootless}
+			},
+			expectedStateDir: RootlessStateDir,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			deploy := newTestDeployment(1, corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}})
+			tt.modify(&deploy.Spec.Template.Spec.Containers[0])
+
+			clientset := fake.NewSimpleClientset(deploy)
+			driver, err := newKubernetesDriver(ctx, clientset, &fakeExecutor{}, testNamespace, testDeployment)
+			require.NoError(t, err)
+
+			stateDir, err := driver.StateDir(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStateDir, stateDir)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package buildkit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Node is a buildkitd instance that belongs to a builder.
+type Node struct {
+	// ID identifies the node among nodes of the same builder.
+	// It is derived from the platform of the node (e.g. `linux-amd64`),
+	// because node names of builders created by CI are usually random and change on every run.
+	ID string
+	Driver
+}
+
+type platformer interface {
+	platform(ctx context.Context) (string, error)
+}
+
+// newNodes identifies drivers by their platforms.
+// It fails rather than falling back to the index of driver for builders of multiple nodes,
+// because cache keys are qualified by the ID and the fallback would change keys between save and load.
+// Builders of a single node use keys as is, so the ID is only informative for them.
+func newNodes(ctx context.Context, drivers []Driver) ([]Node, error) {
+	nodes := make([]Node, 0, len(drivers))
+	seen := make(map[string]struct{}, len(drivers))
+	for i, driver := range drivers {
+		id, err := nodeID(ctx, driver)
+		if err != nil {
+			if len(drivers) > 1 {
+				return nil, pkgerrors.WithMessagef(err, "failed to identify node %d of the builder", i)
+			}
+			id = "node" + strconv.Itoa(i)
+		}
+		if _, duplicated := seen[id]; duplicated {
+			id += "-" + strconv.Itoa(i)
+		}
+		seen[id] = struct{}{}
+
+		nodes = append(nodes, Node{ID: id, Driver: driver})
+	}
+	return nodes, nil
+}
+
+func nodeID(ctx context.Context, driver Driver) (string, error) {
+	p, ok := driver.(platformer)
+	if !ok {
+		return "", pkgerrors.New("driver does not expose its platform")
+	}
+	platform, err := p.platform(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(platform, "/", "-"), nil
+}
+
+func (d *commonDriver) platform(ctx context.Context) (string, error) {
+	workers, err := d.bkClient.ListWorkers(ctx)
+	if err != nil {
+		return "", pkgerrors.WithStack(err)
+	}
+	for _, worker := range workers {
+		if len(worker.Platforms) > 0 {
+			return platforms.Format(worker.Platforms[0]), nil
+		}
+	}
+	return "", pkgerrors.New("no platform found from workers")
+}
+
+type buildxInstance struct {
+	Driver string
+	Nodes  []buildxNode
+}
+
+type buildxNode struct {
+	Name       string
+	Endpoint   string
+	DriverOpts map[string]string
+}
+
+// DetectDriverName returns the name of buildx driver (e.g. `docker-container`, `kubernetes`) of the builder.
+// It returns DriverDockerContainer if the builder is not found from the store of buildx.
+func DetectDriverName(builderName string) string {
+	instance, err := readBuildxInstance(builderName)
+	if err != nil || instance.Driver == "" {
+		return DriverDockerContainer
+	}
+	return instance.Driver
+}
+
+// readBuildxNodeNames reads node names of the builder from the store of buildx.
+func readBuildxNodeNames(builderName string) ([]string, error) {
+	instance, err := readBuildxInstance(builderName)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(instance.Nodes))
+	for _, node := range instance.Nodes {
+		names = append(names, node.Name)
+	}
+	return names, nil
+}
+
+func readBuildxInstance(builderName string) (buildxInstance, error) {
+	configDir := os.Getenv("BUILDX_CONFIG")
+	if configDir == "" {
+		dockerConfigDir := os.Getenv("DOCKER_CONFIG")
+		if dockerConfigDir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return buildxInstance{}, pkgerrors.WithStack(err)
+			}
+			dockerConfigDir = filepath.Join(home, ".docker")
+		}
+		configDir = filepath.Join(dockerConfigDir, "buildx")
+	}
+
+	raw, err := os.ReadFile(filepath.Join(configDir, "instances", strings.ToLower(builderName)))
+	if err != nil {
+		return buildxInstance{}, pkgerrors.WithStack(err)
+	}
+
+	var instance buildxInstance
+	if err = json.Unmarshal(raw, &instance); err != nil {
+		return buildxInstance{}, pkgerrors.WithStack(err)
+	}
+	return instance, nil
+}
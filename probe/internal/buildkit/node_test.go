@@ -0,0 +1,83 @@
+package buildkit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// platformDriver reports platform, or fails with err.
+type platformDriver struct {
+	Driver
+	platformName string
+	err          error
+}
+
+func (d platformDriver) platform(context.Context) (string, error) {
+	return d.platformName, d.err
+}
+
+func TestNewNodes(t *testing.T) {
+	t.Parallel()
+
+	unreachable := errors.New("connection refused")
+	tests := []struct {
+		name          string
+		drivers       []Driver
+		expectedIDs   []string
+		expectedError bool
+	}{
+		{
+			name: "multiple platforms",
+			drivers: []Driver{
+				platformDriver{platformName: "linux/amd64"},
+				platformDriver{platformName: "linux/arm64/v8"},
+			},
+			expectedIDs: []string{"linux-amd64", "linux-arm64-v8"},
+		},
+		{
+			name: "same platforms",
+			drivers: []Driver{
+				platformDriver{platformName: "linux/amd64"},
+				platformDriver{platformName: "linux/amd64"},
+			},
+			expectedIDs: []string{"linux-amd64", "linux-amd64-1"},
+		},
+		{
+			name:        "single node without platform",
+			drivers:     []Driver{platformDriver{err: unreachable}},
+			expectedIDs: []string{"node0"},
+		},
+		{
+			name: "multiple nodes without platform",
+			drivers: []Driver{
+				platformDriver{platformName: "linux/amd64"},
+				platformDriver{err: unreachable},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			nodes, err := newNodes(context.Background(), tt.drivers)
+			if tt.expectedError {
+				assert.ErrorIs(t, err, unreachable)
+				return
+			}
+			require.NoError(t, err)
+
+			ids := make([]string, 0, len(nodes))
+			for _, node := range nodes {
+				ids = append(ids, node.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
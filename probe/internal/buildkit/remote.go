@@ -0,0 +1,165 @@
+package buildkit
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+	bkclient "github.com/moby/buildkit/client"
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const remoteReadyTimeout = time.Minute
+
+// RemoteOptions configures connection to a standalone buildkitd and how to stop and start it.
+type RemoteOptions struct {
+	// Endpoint is address of buildkitd (e.g. `tcp://buildkitd:1234`, `unix:///run/buildkit/buildkitd.sock`).
+	Endpoint string
+
+	// TLSServerName overrides the server name to verify the certificate of buildkitd.
+	TLSServerName string
+	// TLSCACert, TLSCert and TLSKey are file paths of certificates for mTLS.
+	TLSCACert string
+	TLSCert   string
+	TLSKey    string
+
+	// StopCommand and StartCommand are shell commands to stop and start buildkitd (e.g. `systemctl stop buildkit`).
+	StopCommand  string
+	StartCommand string
+}
+
+// remoteDaemon is buildkitd that runs on the same host without container (e.g. systemd service),
+// so its state directory is accessed directly from the host filesystem.
+type remoteDaemon struct {
+	opts RemoteOptions
+	commonDriver
+}
+
+// NewRemoteNodes creates driver for standalone buildkitd.
+// Empty fields of opts are filled from buildx builder which uses remote driver, if exists.
+func NewRemoteNodes(ctx context.Context, builderName string, opts RemoteOptions) ([]Node, error) {
+	if instance, err := readBuildxInstance(builderName); err == nil && len(instance.Nodes) > 0 {
+		opts = opts.withBuildxNode(instance.Nodes[0])
+	}
+	if opts.Endpoint == "" {
+		return nil, pkgerrors.New("endpoint of buildkitd is required")
+	}
+
+	d := &remoteDaemon{opts: opts}
+	if err := d.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	return newNodes(ctx, []Driver{d})
+}
+
+func (o RemoteOptions) withBuildxNode(node buildxNode) RemoteOptions {
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+
+	fill(&o.Endpoint, node.Endpoint)
+	fill(&o.TLSServerName, node.DriverOpts["servername"])
+	fill(&o.TLSCACert, node.DriverOpts["cacert"])
+	fill(&o.TLSCert, node.DriverOpts["cert"])
+	fill(&o.TLSKey, node.DriverOpts["key"])
+	return o
+}
+
+func (d *remoteDaemon) connect(ctx context.Context) error {
+	var opts []bkclient.ClientOpt
+	if d.opts.TLSCACert != "" || d.opts.TLSServerName != "" {
+		opts = append(opts, bkclient.WithServerConfig(d.opts.TLSServerName, d.opts.TLSCACert))
+	}
+	if d.opts.TLSCert != "" || d.opts.TLSKey != "" {
+		opts = append(opts, bkclient.WithCredentials(d.opts.TLSCert, d.opts.TLSKey))
+	}
+
+	bkcli, err := bkclient.New(ctx, d.opts.Endpoint, opts...)
+	if err != nil {
+		return err
+	}
+
+	d.replaceClient(bkcli)
+	return nil
+}
+
+func (d *remoteDaemon) Stop(ctx context.Context) error {
+	if d.opts.StopCommand == "" {
+		return pkgerrors.New("stop command of buildkitd is required")
+	}
+	return runShell(ctx, d.opts.StopCommand)
+}
+
+func (d *remoteDaemon) Resume(ctx context.Context) error {
+	if d.opts.StartCommand == "" {
+		return pkgerrors.New("start command of buildkitd is required")
+	}
+	if err := runShell(ctx, d.opts.StartCommand); err != nil {
+		return err
+	}
+
+	if err := d.connect(ctx); err != nil {
+		return err
+	}
+
+	// unlike containers, start command may return before buildkitd listens
+	ctx, cancel := context.WithTimeout(ctx, remoteReadyTimeout)
+	defer cancel()
+	return pkgerrors.WithStack(wait.PollUntilContextCancel(
+		ctx,
+		time.Second,
+		true,
+		func(ctx context.Context) (bool, error) {
+			_, err := d.bkClient.ListWorkers(ctx)
+			return err == nil, nil
+		},
+	))
+}
+
+func runShell(ctx context.Context, command string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to run `%s`: %s", command, output)
+	}
+	return nil
+}
+
+func (d *remoteDaemon) Shell(ctx context.Context, script string) error {
+	return runShell(ctx, script)
+}
+
+// StateDir can not detect the state directory of buildkitd that runs outside of containers,
+// so it is expected to be given explicitly unless it is the default one.
+func (d *remoteDaemon) StateDir(context.Context) (string, error) {
+	return DefaultStateDir, nil
+}
+
+func (d *remoteDaemon) CopyFrom(_ context.Context, path string) (io.ReadCloser, int64, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, 0, pkgerrors.WithStack(err)
+	}
+
+	// same layout with `docker cp`, the archive contains the directory itself
+	contents, err := archive.TarWithOptions(filepath.Dir(path), &archive.TarOptions{
+		IncludeFiles: []string{filepath.Base(path)},
+	})
+	if err != nil {
+		return nil, 0, pkgerrors.WithStack(err)
+	}
+
+	// size of the directory is unknown without walking it
+	return contents, -1, nil
+}
+
+func (d *remoteDaemon) CopyTo(_ context.Context, path string, content io.Reader) error {
+	return pkgerrors.WithStack(archive.Untar(content, path, &archive.TarOptions{}))
+}
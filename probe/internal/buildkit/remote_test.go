@@ -0,0 +1,83 @@
+package buildkit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteDaemon_Copy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src := filepath.Join(t.TempDir(), "buildkit")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "runc-overlayfs", "snapshots"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "cache.db"), []byte("cache"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "runc-overlayfs", "metadata.db"), []byte("meta"), 0o600))
+
+	driver := &remoteDaemon{}
+	contents, _, err := driver.CopyFrom(ctx, src)
+	require.NoError(t, err)
+	defer contents.Close()
+
+	dst := t.TempDir()
+	require.NoError(t, driver.CopyTo(ctx, dst, contents))
+
+	data, err := os.ReadFile(filepath.Join(dst, "buildkit", "cache.db"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cache"), data)
+	data, err = os.ReadFile(filepath.Join(dst, "buildkit", "runc-overlayfs", "metadata.db"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("meta"), data)
+	assert.DirExists(t, filepath.Join(dst, "buildkit", "runc-overlayfs", "snapshots"))
+}
+
+func TestRemoteDaemon_Stop(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	marker := filepath.Join(t.TempDir(), "stopped")
+
+	driver := &remoteDaemon{opts: RemoteOptions{StopCommand: "touch " + marker}}
+	require.NoError(t, driver.Stop(ctx))
+	assert.FileExists(t, marker)
+
+	driver = &remoteDaemon{opts: RemoteOptions{StopCommand: "echo failure >&2; exit 1"}}
+	assert.ErrorContains(t, driver.Stop(ctx), "failure")
+
+	driver = &remoteDaemon{}
+	assert.Error(t, driver.Stop(ctx))
+	assert.Error(t, driver.Resume(ctx))
+}
+
+func TestRemoteOptions_WithBuildxNode(t *testing.T) {
+	t.Parallel()
+
+	node := buildxNode{
+		Name:     "remote0",
+		Endpoint: "tcp://buildkitd:1234",
+		DriverOpts: map[string]string{
+			"servername": "buildkitd",
+			"cacert":     "/certs/ca.pem",
+			"cert":       "/certs/cert.pem",
+			"key":        "/certs/key.pem",
+		},
+	}
+
+	opts := RemoteOptions{Endpoint: "tcp://override:1234", TLSKey: "/override/key.pem"}.withBuildxNode(node)
+	assert.Equal(
+		t,
+		RemoteOptions{
+			Endpoint:      "tcp://override:1234",
+			TLSServerName: "buildkitd",
+			TLSCACert:     "/certs/ca.pem",
+			TLSCert:       "/certs/cert.pem",
+			TLSKey:        "/override/key.pem",
+		},
+		opts,
+	)
+}
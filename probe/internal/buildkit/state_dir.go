@@ -0,0 +1,31 @@
+package buildkit
+
+import (
+	"strings"
+)
+
+const (
+	// DefaultStateDir is the state directory of buildkitd running as root.
+	DefaultStateDir = "/var/lib/buildkit"
+	// RootlessStateDir is the state directory of rootless buildkitd (e.g. `moby/buildkit:rootless`).
+	RootlessStateDir = "/home/user/.local/share/buildkit"
+)
+
+// stateDirFromArgs finds the value of `--root` flag from command line of buildkitd.
+func stateDirFromArgs(args []string) (string, bool) {
+	for i, arg := range args {
+		if value, found := strings.CutPrefix(arg, "--root="); found {
+			return value, true
+		}
+		if arg == "--root" && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// isRootUser checks whether the user of container (e.g. `1000:1000`, `user`) is root.
+func isRootUser(user string) bool {
+	user, _, _ = strings.Cut(user, ":")
+	return user == "" || user == "root" || user == "0"
+}
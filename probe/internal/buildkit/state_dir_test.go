@@ -0,0 +1,69 @@
+package buildkit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateDirFromArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		args             []string
+		expectedStateDir string
+		found            bool
+	}{
+		{
+			name:             "no flags",
+			args:             []string{"buildkitd"},
+			expectedStateDir: "",
+			found:            false,
+		},
+		{
+			name:             "separated value",
+			args:             []string{"buildkitd", "--debug", "--root", "/data/buildkit"},
+			expectedStateDir: "/data/buildkit",
+			found:            true,
+		},
+		{
+			name:             "joined value",
+			args:             []string{"rootlesskit", "buildkitd", "--root=/data/buildkit", "--oci-worker-no-process-sandbox"},
+			expectedStateDir: "/data/buildkit",
+			found:            true,
+		},
+		{
+			name:             "missing value",
+			args:             []string{"buildkitd", "--root"},
+			expectedStateDir: "",
+			found:            false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stateDir, found := stateDirFromArgs(tt.args)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.expectedStateDir, stateDir)
+		})
+	}
+}
+
+func TestIsRootUser(t *testing.T) {
+	t.Parallel()
+
+	for user, expected := range map[string]bool{
+		"":          true,
+		"root":      true,
+		"0":         true,
+		"0:0":       true,
+		"user":      false,
+		"1000:1000": false,
+	} {
+		assert.Equal(t, expected, isRootUser(user), user)
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+const metadataChecksum = "sha256"
+
+// checksumReader computes SHA-256 of data while it is read, so that data is verified while streaming.
+type checksumReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+	eof    bool
+}
+
+func newChecksumReader(reader io.Reader) *checksumReader {
+	return &checksumReader{reader: reader, hash: sha256.New()}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	if errors.Is(err, io.EOF) {
+		r.eof = true
+	}
+	return n, err
+}
+
+// checksum returns SHA-256 of data. It fails if data is not read to the end yet.
+func (r *checksumReader) checksum() (string, error) {
+	if !r.eof {
+		return "", pkgerrors.New("checksum is requested before data is read to the end")
+	}
+	return hex.EncodeToString(r.hash.Sum(nil)), nil
+}
+
+// verify reads the rest of data, and compares its SHA-256 with the one recorded in metadata.
+// Trailing data that a decoder leaves unread (e.g. padding) is also a part of the checksum.
+// Caches saved by old versions do not have checksum, which is reported by verified.
+func (r *checksumReader) verify(metadata remote.Metadata) (verified bool, err error) {
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return false, pkgerrors.WithStack(err)
+	}
+
+	expected, found := metadata[metadataChecksum]
+	if !found {
+		return false, nil
+	}
+	actual, err := r.checksum()
+	if err != nil {
+		return false, err
+	}
+	if expected != actual {
+		return false, pkgerrors.Errorf("checksum mismatch: expected %s but got %s (%d bytes)", expected, actual, r.size)
+	}
+	return true, nil
+}
+
+// spooledFile is a temporary file that is removed on Close.
+type spooledFile struct {
+	*os.File
+	size int64
+}
+
+func (f spooledFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return pkgerrors.WithStack(err)
+}
+
+// spool writes data into a temporary file, for remotes that have to know metadata before the data is uploaded.
+// The returned file is rewound to the beginning.
+func spool(data io.Reader) (spooledFile, error) {
+	fp, err := os.CreateTemp("", "buildkit-state-*")
+	if err != nil {
+		return spooledFile{}, pkgerrors.WithStack(err)
+	}
+	spooled := spooledFile{File: fp}
+
+	spooled.size, err = io.Copy(fp, data)
+	if err != nil {
+		_ = spooled.Close()
+		return spooledFile{}, pkgerrors.WithStack(err)
+	}
+	if _, err = fp.Seek(0, io.SeekStart); err != nil {
+		_ = spooled.Close()
+		return spooledFile{}, pkgerrors.WithStack(err)
+	}
+	return spooled, nil
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumReader(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("compressed buildkit state")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name             string
+		data             []byte
+		metadata         remote.Metadata
+		expectedVerified bool
+		expectedError    bool
+	}{
+		{
+			name:             "matched",
+			data:             data,
+			metadata:         remote.Metadata{metadataChecksum: checksum},
+			expectedVerified: true,
+			expectedError:    false,
+		},
+		{
+			name:             "truncated",
+			data:             data[:len(data)-1],
+			metadata:         remote.Metadata{metadataChecksum: checksum},
+			expectedVerified: false,
+			expectedError:    true,
+		},
+		{
+			name:             "without checksum",
+			data:             data,
+			metadata:         remote.Metadata{},
+			expectedVerified: false,
+			expectedError:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reader := newChecksumReader(bytes.NewReader(tt.data))
+			// the consumer may stop before the end, and the rest is read by verify
+			head := make([]byte, 5)
+			_, err := io.ReadFull(reader, head)
+			require.NoError(t, err)
+			assert.Equal(t, tt.data[:5], head)
+
+			verified, err := reader.verify(tt.metadata)
+			if tt.expectedError {
+				assert.ErrorContains(t, err, "checksum mismatch")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedVerified, verified)
+		})
+	}
+}
+
+func TestChecksumReader_NotReadToEnd(t *testing.T) {
+	t.Parallel()
+
+	reader := newChecksumReader(bytes.NewReader([]byte("data")))
+	_, err := reader.checksum()
+	assert.Error(t, err)
+
+	_, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	checksum, err := reader.checksum()
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte("data"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), checksum)
+}
+
+func TestSpool(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("compressed buildkit state")
+	spooled, err := spool(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer spooled.Close()
+
+	assert.Equal(t, int64(len(data)), spooled.size)
+	restored, err := io.ReadAll(spooled)
+	require.NoError(t, err)
+	assert.Equal(t, data, restored)
+}
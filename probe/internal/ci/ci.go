@@ -0,0 +1,79 @@
+// Package ci abstracts CI services that the probe runs on,
+// so that the same workflows work on GitHub Actions, GitLab CI and others.
+package ci
+
+import (
+	"os"
+	"strings"
+
+	"github.com/sethvargo/go-githubactions"
+	"golang.org/x/exp/slices"
+)
+
+// CI reads inputs, writes outputs, keeps states between steps of a job and prints logs.
+// Names of inputs, outputs and states are kebab-case like inputs of action.yaml (e.g. `cache-key`).
+type CI interface {
+	GetInput(name string) string
+	SetOutput(name, value string)
+	// SaveState keeps value for GetState of later steps (e.g. the post step that saves cache) of the same job.
+	SaveState(name, value string)
+	GetState(name string) string
+	// IsDebug reports whether verbose logs (e.g. disk usage of buildkitd) are requested.
+	IsDebug() bool
+
+	// Group starts a collapsible region of logs up to the next EndGroup.
+	Group(title string)
+	EndGroup()
+
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warningf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// GetMultilineInput splits input by lines, dropping empty ones.
+func GetMultilineInput(c CI, name string) []string {
+	raw := c.GetInput(name)
+	if raw == "" {
+		return nil
+	}
+	splitted := strings.Split(raw, "\n")
+	return slices.DeleteFunc(splitted, func(s string) bool {
+		return s == ""
+	})
+}
+
+// Detect chooses CI by environment variables that CI services set, and falls back to Plain.
+// inputs (e.g. from command line flags) take precedence over inputs of the CI.
+func Detect(inputs map[string]string) CI {
+	switch {
+	case IsGitHubActions():
+		return WithInputs(NewGitHub(githubactions.New()), inputs)
+	case os.Getenv("GITLAB_CI") == "true":
+		return NewGitLab(GitLabOptions{Inputs: inputs})
+	default:
+		return NewPlain(PlainOptions{Inputs: inputs})
+	}
+}
+
+func IsGitHubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// overridden overrides inputs of CI, e.g. by command line flags.
+type overridden struct {
+	CI
+	inputs map[string]string
+}
+
+// WithInputs overrides inputs of c. Inputs that are not in inputs are still read from c.
+func WithInputs(c CI, inputs map[string]string) CI {
+	return overridden{c, inputs}
+}
+
+func (o overridden) GetInput(name string) string {
+	if value, found := o.inputs[name]; found {
+		return strings.TrimSpace(value)
+	}
+	return o.CI.GetInput(name)
+}
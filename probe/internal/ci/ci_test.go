@@ -1,4 +1,4 @@
-package gha
+package ci
 
 import (
 	"testing"
@@ -70,10 +70,10 @@ func Test_GetMultilineInput(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			gha := githubactions.New(githubactions.WithGetenv(func(key string) string {
+			runner := NewGitHub(githubactions.New(githubactions.WithGetenv(func(key string) string {
 				return tc.env[key]
-			}))
-			actual := GetMultilineInput(gha, tc.variableName)
+			})))
+			actual := GetMultilineInput(runner, tc.variableName)
 			assert.Equal(t, tc.expected, actual)
 		})
 	}
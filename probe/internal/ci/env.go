@@ -0,0 +1,120 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// envPrefix prefixes environment variables of inputs (e.g. `BUILDKIT_STATE_CACHE_KEY` for `cache-key`).
+	envPrefix = "BUILDKIT_STATE_"
+	// envStateFile is the environment variable of the file that keeps states between steps.
+	envStateFile = envPrefix + "STATE_FILE"
+	// inputDebug enables debug logs on CI services that do not have their own debug mode.
+	inputDebug = "debug"
+
+	defaultStateFileName = "buildkit-state.json"
+)
+
+// envName converts name of input or output into environment variable (e.g. `cache-key` into `CACHE_KEY`).
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+}
+
+// envInputs reads inputs from overrides, then from environment variables prefixed by envPrefix.
+type envInputs struct {
+	overrides map[string]string
+	getenv    func(string) string
+}
+
+func (i envInputs) GetInput(name string) string {
+	if value, found := i.overrides[name]; found {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(i.getenv(envPrefix + envName(name)))
+}
+
+func (i envInputs) isDebug() bool {
+	debug, _ := strconv.ParseBool(i.GetInput(inputDebug))
+	return debug
+}
+
+// stateFile keeps states as a JSON object, because steps of a job run as separate processes.
+// Failures are reported by logs rather than errors, same as workflow commands of GitHub Actions.
+type stateFile struct {
+	path string
+	log  func(format string, args ...any)
+}
+
+func (s stateFile) read() (map[string]string, error) {
+	states := make(map[string]string)
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return states, errors.WithStack(json.Unmarshal(raw, &states))
+}
+
+func (s stateFile) SaveState(name, value string) {
+	states, err := s.read()
+	if err != nil {
+		s.log("Failed to read states from %s: %+v", s.path, err)
+		return
+	}
+	states[name] = value
+
+	raw, err := json.Marshal(states)
+	if err == nil {
+		err = os.WriteFile(s.path, raw, 0o600)
+	}
+	if err != nil {
+		s.log("Failed to save state %s into %s: %+v", name, s.path, errors.WithStack(err))
+	}
+}
+
+func (s stateFile) GetState(name string) string {
+	states, err := s.read()
+	if err != nil {
+		s.log("Failed to read states from %s: %+v", s.path, err)
+		return ""
+	}
+	return states[name]
+}
+
+func stateFilePath(path string, getenv func(string) string, defaultName string) string {
+	if path != "" {
+		return path
+	}
+	if path = getenv(envStateFile); path != "" {
+		return path
+	}
+	return filepath.Join(os.TempDir(), defaultName)
+}
+
+// logger prints logs line by line, prefixing each line.
+type logger struct {
+	w     io.Writer
+	debug bool
+}
+
+func (l logger) printf(prefix, format string, args ...any) {
+	for _, line := range strings.Split(fmt.Sprintf(format, args...), "\n") {
+		_, _ = fmt.Fprintln(l.w, prefix+line)
+	}
+}
+
+func (l logger) Debugf(format string, args ...any) {
+	if l.debug {
+		l.printf("[debug] ", format, args...)
+	}
+}
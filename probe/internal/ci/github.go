@@ -0,0 +1,25 @@
+package ci
+
+import (
+	"github.com/sethvargo/go-githubactions"
+)
+
+// GitHub runs on GitHub Actions, reading inputs of action.yaml and writing outputs and states by workflow commands.
+type GitHub struct {
+	*githubactions.Action
+}
+
+func NewGitHub(action *githubactions.Action) GitHub {
+	return GitHub{action}
+}
+
+// GetState reads state saved by SaveState of the previous steps, which the runner passes by environment variables.
+func (g GitHub) GetState(name string) string {
+	return g.Getenv("STATE_" + name)
+}
+
+func (g GitHub) IsDebug() bool {
+	return g.Getenv("RUNNER_DEBUG") == "1"
+}
+
+var _ CI = GitHub{}
@@ -0,0 +1,146 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// envDotenvFile is the environment variable of the dotenv file that outputs are written into.
+	envDotenvFile     = envPrefix + "DOTENV_FILE"
+	defaultDotenvFile = "buildkit-state.env"
+
+	ansiClearLine = "\x1b[0K"
+	ansiWarning   = "\x1b[33;1m"
+	ansiError     = "\x1b[31;1m"
+	ansiReset     = "\x1b[0m"
+)
+
+// GitLabOptions configures GitLab. Zero values are replaced by defaults.
+type GitLabOptions struct {
+	// Inputs take precedence over environment variables like `BUILDKIT_STATE_CACHE_KEY`.
+	Inputs map[string]string
+	// StateFile keeps states between steps. Defaults to `BUILDKIT_STATE_STATE_FILE` environment variable,
+	// or a file named after the job in the temporary directory.
+	StateFile string
+	// DotenvFile receives outputs, which is meant to be `artifacts:reports:dotenv` of the job.
+	// Defaults to `BUILDKIT_STATE_DOTENV_FILE` environment variable, or `buildkit-state.env`.
+	DotenvFile string
+	// Log receives logs. Defaults to os.Stdout, which is the job log.
+	Log io.Writer
+	// Getenv defaults to os.Getenv.
+	Getenv func(string) string
+	// Now defaults to time.Now. Section markers contain timestamp.
+	Now func() time.Time
+}
+
+// GitLab runs on GitLab CI. Groups are printed as collapsible sections,
+// and outputs are written as upper snake case variables (e.g. `RESTORED_CACHE_KEY`) into the dotenv file,
+// so that later jobs can use them.
+type GitLab struct {
+	envInputs
+	stateFile
+	logger
+	dotenvFile string
+	now        func() time.Time
+
+	sectionCount int
+	sections     []string
+}
+
+func NewGitLab(opts GitLabOptions) *GitLab {
+	if opts.Log == nil {
+		opts.Log = os.Stdout
+	}
+	if opts.Getenv == nil {
+		opts.Getenv = os.Getenv
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.DotenvFile == "" {
+		opts.DotenvFile = opts.Getenv(envDotenvFile)
+	}
+	if opts.DotenvFile == "" {
+		opts.DotenvFile = defaultDotenvFile
+	}
+
+	g := &GitLab{
+		envInputs:  envInputs{opts.Inputs, opts.Getenv},
+		dotenvFile: opts.DotenvFile,
+		now:        opts.Now,
+	}
+	g.logger = logger{opts.Log, g.isDebug() || opts.Getenv("CI_DEBUG_TRACE") == "true"}
+	defaultStateFile := "buildkit-state-" + opts.Getenv("CI_JOB_ID") + ".json"
+	g.stateFile = stateFile{stateFilePath(opts.StateFile, opts.Getenv, defaultStateFile), g.Warningf}
+	return g
+}
+
+// SetOutput appends the output to the dotenv file.
+// Dotenv reports of GitLab do not support multiline values, so such outputs are dropped with warning.
+func (g *GitLab) SetOutput(name, value string) {
+	if strings.ContainsAny(value, "\r\n") {
+		g.Warningf("Output %s is not written into %s, because it has multiple lines.", name, g.dotenvFile)
+		return
+	}
+
+	fp, err := os.OpenFile(g.dotenvFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		g.Warningf("Failed to open %s: %+v", g.dotenvFile, errors.WithStack(err))
+		return
+	}
+	defer fp.Close()
+
+	if _, err = fmt.Fprintf(fp, "%s=%s\n", envName(name), value); err != nil {
+		g.Warningf("Failed to write output %s into %s: %+v", name, g.dotenvFile, errors.WithStack(err))
+	}
+}
+
+func (g *GitLab) IsDebug() bool {
+	return g.debug
+}
+
+// Group starts a collapsed section. Names of sections are generated, because titles may have disallowed characters.
+func (g *GitLab) Group(title string) {
+	g.sectionCount++
+	name := "buildkit_state_" + strconv.Itoa(g.sectionCount)
+	g.sections = append(g.sections, name)
+	_, _ = fmt.Fprintf(
+		g.w,
+		"%ssection_start:%d:%s[collapsed=true]\r%s%s\n",
+		ansiClearLine,
+		g.now().Unix(),
+		name,
+		ansiClearLine,
+		title,
+	)
+}
+
+func (g *GitLab) EndGroup() {
+	if len(g.sections) == 0 {
+		return
+	}
+	name := g.sections[len(g.sections)-1]
+	g.sections = g.sections[:len(g.sections)-1]
+	_, _ = fmt.Fprintf(g.w, "%ssection_end:%d:%s\r%s\n", ansiClearLine, g.now().Unix(), name, ansiClearLine)
+}
+
+func (g *GitLab) Infof(format string, args ...any) {
+	g.printf("", format, args...)
+}
+
+func (g *GitLab) Warningf(format string, args ...any) {
+	g.printf(ansiWarning+"WARNING: "+ansiReset, format, args...)
+}
+
+func (g *GitLab) Errorf(format string, args ...any) {
+	g.printf(ansiError+"ERROR: "+ansiReset, format, args...)
+}
+
+var _ CI = (*GitLab)(nil)
@@ -0,0 +1,57 @@
+package ci
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitLab(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dotenvFile := filepath.Join(dir, "buildkit-state.env")
+	env := map[string]string{
+		"BUILDKIT_STATE_CACHE_KEY":   "key",
+		"BUILDKIT_STATE_STATE_FILE":  filepath.Join(dir, "state.json"),
+		"BUILDKIT_STATE_DOTENV_FILE": dotenvFile,
+		"CI_DEBUG_TRACE":             "true",
+	}
+	log := new(bytes.Buffer)
+	gitlab := NewGitLab(GitLabOptions{
+		Log:    log,
+		Getenv: func(key string) string { return env[key] },
+		Now:    func() time.Time { return time.Unix(1700000000, 0) },
+	})
+
+	assert.Equal(t, "key", gitlab.GetInput("cache-key"))
+	assert.True(t, gitlab.IsDebug())
+
+	gitlab.Group("Load cache from remote (linux/amd64)")
+	gitlab.Debugf("primary key: key")
+	gitlab.Warningf("careful")
+	gitlab.EndGroup()
+	assert.Equal(
+		t,
+		"\x1b[0Ksection_start:1700000000:buildkit_state_1[collapsed=true]\r\x1b[0KLoad cache from remote (linux/amd64)\n"+
+			"[debug] primary key: key\n"+
+			"\x1b[33;1mWARNING: \x1b[0mcareful\n"+
+			"\x1b[0Ksection_end:1700000000:buildkit_state_1\r\x1b[0K\n",
+		log.String(),
+	)
+
+	gitlab.SetOutput("restored-cache-key", "key")
+	gitlab.SetOutput("restored-cache-key-linux-arm64", "arm64-key")
+	gitlab.SetOutput("multiline", "a\nb")
+	dotenv, err := os.ReadFile(dotenvFile)
+	require.NoError(t, err)
+	assert.Equal(t, "RESTORED_CACHE_KEY=key\nRESTORED_CACHE_KEY_LINUX_ARM64=arm64-key\n", string(dotenv))
+
+	gitlab.SaveState("loaded-cache-key", "key")
+	assert.Equal(t, "key", NewGitLab(GitLabOptions{Getenv: gitlab.getenv}).GetState("loaded-cache-key"))
+}
@@ -0,0 +1,97 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PlainOptions configures Plain. Zero values are replaced by defaults.
+type PlainOptions struct {
+	// Inputs take precedence over environment variables like `BUILDKIT_STATE_CACHE_KEY`.
+	Inputs map[string]string
+	// StateFile keeps states between steps.
+	// Defaults to `BUILDKIT_STATE_STATE_FILE` environment variable, or a file in the temporary directory
+	// named after the job (e.g. `buildkit-state-<BUILDKITE_JOB_ID>.json`), so that jobs on the same agent do not share it.
+	StateFile string
+	// Output receives outputs as `name=value` lines. Defaults to os.Stdout.
+	Output io.Writer
+	// Log receives logs. Defaults to os.Stderr.
+	Log io.Writer
+	// Getenv defaults to os.Getenv.
+	Getenv func(string) string
+}
+
+// Plain runs on any shell, such as Jenkins, Buildkite or local machines.
+type Plain struct {
+	envInputs
+	stateFile
+	logger
+	output io.Writer
+}
+
+func NewPlain(opts PlainOptions) Plain {
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+	if opts.Log == nil {
+		opts.Log = os.Stderr
+	}
+	if opts.Getenv == nil {
+		opts.Getenv = os.Getenv
+	}
+
+	p := Plain{
+		envInputs: envInputs{opts.Inputs, opts.Getenv},
+		output:    opts.Output,
+	}
+	p.logger = logger{opts.Log, p.isDebug()}
+	p.stateFile = stateFile{stateFilePath(opts.StateFile, opts.Getenv, plainStateFileName(opts.Getenv)), p.Warningf}
+	return p
+}
+
+// plainRunIDEnvs are environment variables that identify the job on CI services without their own integration.
+var plainRunIDEnvs = []string{
+	"BUILDKITE_JOB_ID",
+	// Jenkins
+	"BUILD_TAG",
+}
+
+func plainStateFileName(getenv func(string) string) string {
+	for _, env := range plainRunIDEnvs {
+		if runID := getenv(env); runID != "" {
+			return "buildkit-state-" + strings.ReplaceAll(runID, string(filepath.Separator), "_") + ".json"
+		}
+	}
+	return defaultStateFileName
+}
+
+func (p Plain) SetOutput(name, value string) {
+	_, _ = fmt.Fprintf(p.output, "%s=%s\n", name, value)
+}
+
+func (p Plain) IsDebug() bool {
+	return p.debug
+}
+
+func (p Plain) Group(title string) {
+	p.printf("", "==> %s", title)
+}
+
+func (p Plain) EndGroup() {}
+
+func (p Plain) Infof(format string, args ...any) {
+	p.printf("", format, args...)
+}
+
+func (p Plain) Warningf(format string, args ...any) {
+	p.printf("[warning] ", format, args...)
+}
+
+func (p Plain) Errorf(format string, args ...any) {
+	p.printf("[error] ", format, args...)
+}
+
+var _ CI = Plain{}
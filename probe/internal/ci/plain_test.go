@@ -0,0 +1,88 @@
+package ci
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlain(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"BUILDKIT_STATE_CACHE_KEY":      "from-env",
+		"BUILDKIT_STATE_TARGET_TYPES":   "exec.cachemount\nfrontend",
+		"BUILDKIT_STATE_RESUME_BUILDER": "true",
+	}
+	newPlain := func(output, log *bytes.Buffer, stateFile string) Plain {
+		return NewPlain(PlainOptions{
+			Inputs:    map[string]string{"resume-builder": "false"},
+			StateFile: stateFile,
+			Output:    output,
+			Log:       log,
+			Getenv:    func(key string) string { return env[key] },
+		})
+	}
+
+	output := new(bytes.Buffer)
+	log := new(bytes.Buffer)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	plain := newPlain(output, log, stateFile)
+
+	assert.Equal(t, "from-env", plain.GetInput("cache-key"))
+	assert.Equal(t, "false", plain.GetInput("resume-builder"), "inputs take precedence over environment variables")
+	assert.Equal(t, []string{"exec.cachemount", "frontend"}, GetMultilineInput(plain, "target-types"))
+	assert.Empty(t, plain.GetInput("compression"))
+	assert.False(t, plain.IsDebug())
+
+	plain.SetOutput("restored-cache-key", "key")
+	assert.Equal(t, "restored-cache-key=key\n", output.String())
+
+	plain.Group("Load cache")
+	plain.Debugf("hidden")
+	plain.Infof("multiple\nlines")
+	plain.Warningf("careful")
+	plain.EndGroup()
+	assert.Equal(t, "==> Load cache\nmultiple\nlines\n[warning] careful\n", log.String())
+
+	// states are kept for the later process
+	assert.Empty(t, plain.GetState("loaded-cache-key"))
+	plain.SaveState("loaded-cache-key", "key")
+	plain.SaveState("loaded-cache-key-linux-arm64", "arm64-key")
+	later := newPlain(output, log, stateFile)
+	assert.Equal(t, "key", later.GetState("loaded-cache-key"))
+	assert.Equal(t, "arm64-key", later.GetState("loaded-cache-key-linux-arm64"))
+}
+
+func TestPlainStateFileName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "buildkite",
+			env:      map[string]string{"BUILDKITE_JOB_ID": "0188-abcd"},
+			expected: "buildkit-state-0188-abcd.json",
+		},
+		{
+			name:     "jenkins",
+			env:      map[string]string{"BUILD_TAG": "jenkins-folder/job-42"},
+			expected: "buildkit-state-jenkins-folder_job-42.json",
+		},
+		{name: "unknown", env: nil, expected: "buildkit-state.json"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.expected, plainStateFileName(func(key string) string { return tt.env[key] }))
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/bits"
+	"path"
+	"runtime"
+	"strconv"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/docker/go-units"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	pkgerrors "github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+type Codec string
+
+const (
+	CodecZstd Codec = "zstd"
+	CodecLZ4  Codec = "lz4"
+	CodecGzip Codec = "gzip"
+	CodecNone Codec = "none"
+
+	// MetadataCodec is the key of remote.Metadata that records Codec of the cache.
+	MetadataCodec      = "codec"
+	metadataWindowSize = "window_size"
+
+	// DefaultWindowLog is the default of `window-size` input, which is log2 of window size of zstd.
+	DefaultWindowLog = 27
+	MinWindowLog     = 10
+
+	// gzip is compressed in parallel by blocks of this size.
+	gzipBlockSize = 1 << 20
+)
+
+var (
+	codecs = []Codec{CodecZstd, CodecLZ4, CodecGzip, CodecNone}
+
+	// MaxWindowLog is limited by the zstd implementation, which is smaller than the one of zstd CLI (31).
+	MaxWindowLog = bits.Len(uint(zstd.MaxWindowSize)) - 1
+
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	lz4Magic  = []byte{0x04, 0x22, 0x4d, 0x18}
+	gzipMagic = []byte{0x1f, 0x8b}
+
+	lz4Levels = []lz4.CompressionLevel{
+		lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+	}
+)
+
+func ParseCodec(name string) (Codec, error) {
+	codec := Codec(name)
+	if !slices.Contains(codecs, codec) {
+		return "", pkgerrors.Errorf("unknown compression: %s. Only supports %v", name, codecs)
+	}
+	return codec, nil
+}
+
+// CompressOptions configures the encoder.
+type CompressOptions struct {
+	Codec Codec
+	Level int
+	// WindowLog is log2 of window size of zstd, ignored by other codecs.
+	// Larger window finds matches from further back in the stream, which acts as long-distance matching,
+	// but the decoder needs as much memory as the window to restore it.
+	WindowLog int
+}
+
+// validate checks options without any side effect, so that invalid inputs fail before buildkitd is stopped.
+func (o CompressOptions) validate() error {
+	var maxLevel int
+	switch o.Codec {
+	case CodecZstd:
+		if o.WindowLog < MinWindowLog || o.WindowLog > MaxWindowLog {
+			return pkgerrors.Errorf("window size must be between %d and %d: %d", MinWindowLog, MaxWindowLog, o.WindowLog)
+		}
+		maxLevel = 22
+	case CodecLZ4:
+		maxLevel = len(lz4Levels)
+	case CodecGzip:
+		maxLevel = 9
+	case CodecNone:
+		return nil
+	default:
+		return pkgerrors.Errorf("unknown compression: %s", o.Codec)
+	}
+	if o.Level < 1 || o.Level > maxLevel {
+		return pkgerrors.Errorf("compression level of %s must be between 1 and %d: %d", o.Codec, maxLevel, o.Level)
+	}
+	return nil
+}
+
+// Metadata returns metadata that has to be stored with the compressed state to decompress it.
+func (o CompressOptions) Metadata() remote.Metadata {
+	metadata := remote.Metadata{MetadataCodec: string(o.Codec)}
+	if o.Codec == CodecZstd {
+		metadata[metadataWindowSize] = strconv.Itoa(o.WindowLog)
+	}
+	return metadata
+}
+
+// Compress streams buildkit state out of bkCli through the encoder.
+// The returned reader must be closed by caller, which also aborts compression if it is still in progress.
+// The returned size is the one reported by bkCli and should only be used as a hint.
+func Compress(
+	ctx context.Context,
+	bkCli buildkit.Driver,
+	stateDir string,
+	opts CompressOptions,
+) (compressed io.ReadCloser, sizeHint int64, err error) {
+	pipeReader, pipeWriter := io.Pipe()
+	writer, err := newEncoder(pipeWriter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	contents, size, err := bkCli.CopyFrom(ctx, stateDir)
+	if err != nil {
+		return nil, 0, errors.Join(err, pkgerrors.WithStack(writer.Close()))
+	}
+	contents = rebaseArchive(contents, path.Base(stateDir), archiveRoot)
+
+	go func() {
+		_, err := io.Copy(writer, contents)
+		err = pkgerrors.WithStack(err)
+		if closeErr := writer.Close(); closeErr != nil && err == nil {
+			err = pkgerrors.WithStack(closeErr)
+		}
+		if closeErr := contents.Close(); closeErr != nil {
+			err = errors.Join(err, pkgerrors.WithStack(closeErr))
+		}
+		_ = pipeWriter.CloseWithError(err)
+	}()
+
+	return pipeReader, size, nil
+}
+
+func newEncoder(writer io.Writer, opts CompressOptions) (io.WriteCloser, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	level := opts.Level
+	switch opts.Codec {
+	case CodecZstd:
+		encoder, err := zstd.NewWriter(
+			writer,
+			zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)),
+			zstd.WithNoEntropyCompression(false),
+			zstd.WithWindowSize(1<<opts.WindowLog),
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		)
+		return encoder, pkgerrors.WithStack(err)
+
+	case CodecLZ4:
+		encoder := lz4.NewWriter(writer)
+		err := encoder.Apply(
+			lz4.ConcurrencyOption(runtime.GOMAXPROCS(0)),
+			lz4.CompressionLevelOption(lz4Levels[level-1]),
+		)
+		return encoder, pkgerrors.WithStack(err)
+
+	case CodecGzip:
+		encoder, err := pgzip.NewWriterLevel(writer, level)
+		if err != nil {
+			return nil, pkgerrors.WithStack(err)
+		}
+		return encoder, pkgerrors.WithStack(encoder.SetConcurrency(gzipBlockSize, runtime.GOMAXPROCS(0)))
+
+	case CodecNone:
+		return nopWriteCloser{writer}, nil
+
+	default:
+		return nil, pkgerrors.Errorf("unknown compression: %s", opts.Codec)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Decompress extracts buildkit state into dest of bkCli, which is either the state directory or a staging one.
+// The codec is read from metadata, or detected from the magic number of body for caches saved by old versions.
+func Decompress(
+	ctx context.Context,
+	bkCli buildkit.Driver,
+	dest string,
+	body io.Reader,
+	metadata remote.Metadata,
+) error {
+	buffered := bufio.NewReader(body)
+	codec, err := detectCodec(buffered, metadata)
+	if err != nil {
+		return err
+	}
+
+	windowSize, err := decoderWindowSize(metadata, codec)
+	if err != nil {
+		return err
+	}
+	reader, err := newDecoder(buffered, codec, windowSize)
+	if err != nil {
+		return err
+	}
+
+	contents := rebaseArchive(reader, archiveRoot, path.Base(dest))
+	defer contents.Close()
+
+	return bkCli.CopyTo(ctx, path.Dir(dest), contents)
+}
+
+func detectCodec(body *bufio.Reader, metadata remote.Metadata) (Codec, error) {
+	if name, found := metadata[MetadataCodec]; found {
+		return ParseCodec(name)
+	}
+
+	magic, err := body.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", pkgerrors.WithStack(err)
+	}
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		return CodecZstd, nil
+	case bytes.HasPrefix(magic, lz4Magic):
+		return CodecLZ4, nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CodecGzip, nil
+	default:
+		return CodecNone, nil
+	}
+}
+
+// CheckDecompressible checks whether this machine has enough memory to decompress the state,
+// so that it fails before touching buildkitd instead of running out of memory in the middle of restoring.
+func CheckDecompressible(metadata remote.Metadata) error {
+	return checkDecompressible(metadata, availableMemory())
+}
+
+// checkDecompressible checks the window of zstd against available memory, which is unknown if 0.
+// Other codecs need little memory to decode. Caches without codec in metadata are saved by old versions,
+// which always use zstd.
+func checkDecompressible(metadata remote.Metadata, available uint64) error {
+	codec := CodecZstd
+	if name, found := metadata[MetadataCodec]; found {
+		var err error
+		if codec, err = ParseCodec(name); err != nil {
+			return err
+		}
+	}
+
+	windowSize, err := decoderWindowSize(metadata, codec)
+	if err != nil {
+		return err
+	}
+
+	if available > 0 && windowSize > available {
+		return pkgerrors.Errorf(
+			"decompression needs %s of memory for the window of zstd, but only %s is available",
+			units.BytesSize(float64(windowSize)),
+			units.BytesSize(float64(available)),
+		)
+	}
+	return nil
+}
+
+// decoderWindowSize returns the window size that the state is compressed with, which is 0 if codec is not zstd.
+// The window of caches saved by old versions is unknown, so the maximum is allowed.
+func decoderWindowSize(metadata remote.Metadata, codec Codec) (uint64, error) {
+	if codec != CodecZstd {
+		return 0, nil
+	}
+	rawWindowLog, found := metadata[metadataWindowSize]
+	if !found {
+		return zstd.MaxWindowSize, nil
+	}
+
+	windowLog, err := strconv.Atoi(rawWindowLog)
+	if err != nil {
+		return 0, pkgerrors.Wrapf(err, "invalid window size in metadata: %s", rawWindowLog)
+	}
+	if windowLog < MinWindowLog || windowLog > MaxWindowLog {
+		return 0, pkgerrors.Errorf("unsupported window size in metadata: %d", windowLog)
+	}
+	return 1 << windowLog, nil
+}
+
+func newDecoder(reader io.Reader, codec Codec, windowSize uint64) (io.ReadCloser, error) {
+	switch codec {
+	case CodecZstd:
+		decoder, err := zstd.NewReader(
+			reader,
+			zstd.WithDecoderLowmem(false),
+			zstd.WithDecoderConcurrency(runtime.GOMAXPROCS(0)),
+			// frames that declare larger window than the one recorded are rejected instead of allocating it
+			zstd.WithDecoderMaxWindow(windowSize),
+		)
+		if err != nil {
+			return nil, pkgerrors.WithStack(err)
+		}
+		return decoder.IOReadCloser(), nil
+
+	case CodecLZ4:
+		decoder := lz4.NewReader(reader)
+		if err := decoder.Apply(lz4.ConcurrencyOption(runtime.GOMAXPROCS(0))); err != nil {
+			return nil, pkgerrors.WithStack(err)
+		}
+		return io.NopCloser(decoder), nil
+
+	case CodecGzip:
+		decoder, err := pgzip.NewReader(reader)
+		if err != nil {
+			return nil, pkgerrors.WithStack(err)
+		}
+		return decoder, nil
+
+	case CodecNone:
+		return io.NopCloser(reader), nil
+
+	default:
+		return nil, pkgerrors.Errorf("unknown compression: %s", codec)
+	}
+}
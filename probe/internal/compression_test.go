@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecs(t *testing.T) {
+	t.Parallel()
+
+	for _, codec := range codecs {
+		codec := codec
+		t.Run(string(codec), func(t *testing.T) {
+			t.Parallel()
+
+			data := make([]byte, 4*1024*1024)
+			_, err := rand.Read(data[:len(data)/2]) // nolint:gosec
+			require.NoError(t, err)
+
+			compressed := new(bytes.Buffer)
+			opts := CompressOptions{Codec: codec, Level: 3, WindowLog: DefaultWindowLog}
+			encoder, err := newEncoder(compressed, opts)
+			require.NoError(t, err)
+			_, err = encoder.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, encoder.Close())
+
+			for name, metadata := range map[string]remote.Metadata{
+				"from metadata":        opts.Metadata(),
+				"detected from legacy": {},
+			} {
+				reader := bufio.NewReader(bytes.NewReader(compressed.Bytes()))
+				detected, err := detectCodec(reader, metadata)
+				require.NoError(t, err, name)
+				assert.Equal(t, codec, detected, name)
+
+				windowSize, err := decoderWindowSize(metadata, detected)
+				require.NoError(t, err, name)
+				decoder, err := newDecoder(reader, detected, windowSize)
+				require.NoError(t, err, name)
+				decompressed, err := io.ReadAll(decoder)
+				require.NoError(t, err, name)
+				require.NoError(t, decoder.Close(), name)
+				assert.Equal(t, data, decompressed, name)
+			}
+		})
+	}
+}
+
+func TestNewEncoder_InvalidLevel(t *testing.T) {
+	t.Parallel()
+
+	for codec, level := range map[Codec]int{CodecZstd: 23, CodecLZ4: 10, CodecGzip: 0} {
+		_, err := newEncoder(io.Discard, CompressOptions{Codec: codec, Level: level, WindowLog: DefaultWindowLog})
+		assert.Error(t, err, codec)
+	}
+	_, err := newEncoder(io.Discard, CompressOptions{Codec: CodecNone})
+	assert.NoError(t, err)
+}
+
+func TestCompressOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		opts             CompressOptions
+		valid            bool
+		expectedMetadata remote.Metadata
+	}{
+		{
+			name:             "zstd",
+			opts:             CompressOptions{Codec: CodecZstd, Level: 3, WindowLog: 23},
+			valid:            true,
+			expectedMetadata: remote.Metadata{MetadataCodec: "zstd", metadataWindowSize: "23"},
+		},
+		{
+			name:             "too large window",
+			opts:             CompressOptions{Codec: CodecZstd, Level: 3, WindowLog: 31},
+			valid:            false,
+			expectedMetadata: remote.Metadata{MetadataCodec: "zstd", metadataWindowSize: "31"},
+		},
+		{
+			name:             "too high level",
+			opts:             CompressOptions{Codec: CodecGzip, Level: 10},
+			valid:            false,
+			expectedMetadata: remote.Metadata{MetadataCodec: "gzip"},
+		},
+		{
+			name:             "window is ignored by other codecs",
+			opts:             CompressOptions{Codec: CodecLZ4, Level: 3, WindowLog: 31},
+			valid:            true,
+			expectedMetadata: remote.Metadata{MetadataCodec: "lz4"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tt.valid {
+				assert.NoError(t, tt.opts.validate())
+			} else {
+				assert.Error(t, tt.opts.validate())
+			}
+			assert.Equal(t, tt.expectedMetadata, tt.opts.Metadata())
+		})
+	}
+}
+
+func TestDecoderWindowSize(t *testing.T) {
+	t.Parallel()
+
+	windowSize, err := decoderWindowSize(remote.Metadata{metadataWindowSize: "20"}, CodecZstd)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1<<20), windowSize)
+
+	windowSize, err = decoderWindowSize(remote.Metadata{}, CodecZstd)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1<<MaxWindowLog), windowSize)
+
+	_, err = decoderWindowSize(remote.Metadata{metadataWindowSize: "31"}, CodecZstd)
+	assert.Error(t, err)
+
+	windowSize, err = decoderWindowSize(remote.Metadata{}, CodecLZ4)
+	require.NoError(t, err)
+	assert.Zero(t, windowSize)
+
+	require.NoError(t, CheckDecompressible(remote.Metadata{metadataWindowSize: "10"}))
+}
+
+func TestCheckDecompressible(t *testing.T) {
+	t.Parallel()
+
+	const lowMemory = 64 << 20
+
+	tests := []struct {
+		name          string
+		metadata      remote.Metadata
+		available     uint64
+		expectedError bool
+	}{
+		{
+			name:      "zstd with small window",
+			metadata:  CompressOptions{Codec: CodecZstd, Level: 3, WindowLog: 20}.Metadata(),
+			available: lowMemory,
+		},
+		{
+			name:          "zstd with large window",
+			metadata:      CompressOptions{Codec: CodecZstd, Level: 3, WindowLog: 27}.Metadata(),
+			available:     lowMemory,
+			expectedError: true,
+		},
+		{
+			name:          "legacy cache of unknown window",
+			metadata:      remote.Metadata{},
+			available:     lowMemory,
+			expectedError: true,
+		},
+		{
+			name:      "unknown available memory",
+			metadata:  remote.Metadata{},
+			available: 0,
+		},
+		{
+			name:      "lz4",
+			metadata:  CompressOptions{Codec: CodecLZ4, Level: 1}.Metadata(),
+			available: lowMemory,
+		},
+		{
+			name:      "gzip",
+			metadata:  CompressOptions{Codec: CodecGzip, Level: 6}.Metadata(),
+			available: lowMemory,
+		},
+		{
+			name:      "none",
+			metadata:  CompressOptions{Codec: CodecNone}.Metadata(),
+			available: lowMemory,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkDecompressible(tt.metadata, tt.available)
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDecoder_RejectsLargerWindow(t *testing.T) {
+	t.Parallel()
+
+	data := make([]byte, 4*1024*1024)
+	_, err := rand.Read(data) // nolint:gosec
+	require.NoError(t, err)
+
+	compressed := new(bytes.Buffer)
+	encoder, err := newEncoder(compressed, CompressOptions{Codec: CodecZstd, Level: 3, WindowLog: 22})
+	require.NoError(t, err)
+	_, err = encoder.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, encoder.Close())
+
+	decoder, err := newDecoder(bytes.NewReader(compressed.Bytes()), CodecZstd, 1<<MinWindowLog)
+	require.NoError(t, err)
+	defer decoder.Close()
+	_, err = io.ReadAll(decoder)
+	assert.Error(t, err)
+}
+
+func TestParseCodec(t *testing.T) {
+	t.Parallel()
+
+	codec, err := ParseCodec("lz4")
+	require.NoError(t, err)
+	assert.Equal(t, CodecLZ4, codec)
+
+	_, err = ParseCodec("brotli")
+	assert.Error(t, err)
+}
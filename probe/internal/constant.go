@@ -1,17 +1,28 @@
 package internal
 
+// Names of inputs of the workflows, which are the same with action.yaml.
+// The command line defines a flag of the same name for each of them.
 const (
-	BuildKitStateSaveDir = "/var/lib/buildkit"
-	BuildKitStateLoadDir = "/var/lib"
-
-	inputPrimaryKey       = "cache-key"
-	inputSecondaryKeys    = "cache-restore-keys"
-	inputTargetTypes      = "target-types"
-	inputRewriteCache     = "rewrite-cache"
-	inputResumeBuilder    = "resume-builder"
-	inputCompressionLevel = "compression-level"
+	InputPrimaryKey       = "cache-key"
+	InputSecondaryKeys    = "cache-restore-keys"
+	InputTargetTypes      = "target-types"
+	InputRewriteCache     = "rewrite-cache"
+	InputResumeBuilder    = "resume-builder"
+	InputCompressionLevel = "compression-level"
+	InputCompression      = "compression"
+	InputStateDir         = "state-dir"
+	InputWindowSize       = "window-size"
+	// InputMaxRestoreAttempts caps the number of caches that are tried until one of them is restored.
+	InputMaxRestoreAttempts = "max-restore-attempts"
+	// InputRetention is retention policies of CollectGarbage, one per line.
+	InputRetention = "retention"
+	InputDryRun    = "dry-run"
+)
 
+const (
 	outputRestoredCacheKey = "restored-cache-key"
 
 	stateLoadedCacheKey = "loaded-cache-key"
+
+	DefaultMaxRestoreAttempts = 3
 )
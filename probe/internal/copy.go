@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+// CopyBetweenRemotes copies caches whose key starts with prefix from src to dst, e.g. to migrate to another remote.
+// Data is streamed as stored without decompressing, and keys and metadata are preserved.
+// Caches are copied from the oldest one, so that dst resolves restore keys into the same caches as src.
+// Caches that already exist in dst are skipped unless `rewrite-cache` input is true,
+// and with `dry-run` input, it only reports caches to be copied.
+func CopyBetweenRemotes(ctx context.Context, runner ci.CI, src, dst remote.Manager, prefix string) (err error) {
+	defer func() {
+		if err != nil {
+			runner.Errorf("Failed to copy caches: %+v", err)
+		}
+	}()
+
+	lister, ok := src.(remote.Lister)
+	if !ok {
+		return errors.New("source remote does not support listing")
+	}
+	rewrite, err := parseOptionalBool(runner, InputRewriteCache)
+	if err != nil {
+		return err
+	}
+	dryRun, err := parseOptionalBool(runner, InputDryRun)
+	if err != nil {
+		return err
+	}
+
+	runner.Group("Copy caches between remotes")
+	defer runner.EndGroup()
+
+	entries, err := lister.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(entries, func(a, b remote.Entry) bool {
+		if !a.Modified.Equal(b.Modified) {
+			return a.Modified.Before(b.Modified)
+		}
+		return a.Key < b.Key
+	})
+
+	var copiedKeys []string
+	var copiedSize int64
+	for _, entry := range entries {
+		// the same key is listed multiple times if the remote keeps a cache per branch (e.g. GitHub Actions)
+		if slices.Contains(copiedKeys, entry.Key) {
+			continue
+		}
+
+		if !rewrite {
+			existing, err := dst.Load(ctx, entry.Key, nil)
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				runner.Infof("Skip %s, which already exists", entry.Key)
+				continue
+			}
+		}
+
+		size := "unknown size"
+		if entry.Size >= 0 {
+			size = units.HumanSize(float64(entry.Size))
+		}
+		if dryRun {
+			runner.Infof("Would copy %s (%s, saved at %s)", entry.Key, size, entry.Modified.Format(time.RFC3339))
+		} else {
+			runner.Infof("Copying %s (%s, saved at %s)", entry.Key, size, entry.Modified.Format(time.RFC3339))
+			if err = copyCache(ctx, src, dst, entry); err != nil {
+				return err
+			}
+		}
+		copiedKeys = append(copiedKeys, entry.Key)
+		if entry.Size > 0 {
+			copiedSize += entry.Size
+		}
+	}
+
+	verb := "Copied"
+	if dryRun {
+		verb = "Would copy"
+	}
+	runner.Infof("%s %d of %d caches, %s", verb, len(copiedKeys), len(entries), units.HumanSize(float64(copiedSize)))
+	return nil
+}
+
+// copyCache fails if the listed cache can not be loaded,
+// because its key does not round-trip through the source remote and nothing would be copied.
+func copyCache(ctx context.Context, src, dst remote.Manager, entry remote.Entry) error {
+	candidates, err := src.Load(ctx, entry.Key, nil)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(candidates, func(c remote.Candidate) bool { return c.Key == entry.Key })
+	if i < 0 {
+		return errors.Errorf("%s is listed but can not be loaded from source remote", entry.Key)
+	}
+
+	loaded, err := candidates[i].Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer loaded.Data.Close()
+
+	return dst.Save(ctx, entry.Key, loaded.Data, entry.Size, loaded.Metadata)
+}
+
+// parseOptionalBool parses boolean input, which is false if empty.
+func parseOptionalBool(runner ci.CI, name string) (bool, error) {
+	raw := runner.GetInput(name)
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, errors.Wrapf(err, `failed to parse "%s"`, name)
+	}
+	return value, nil
+}
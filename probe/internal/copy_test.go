@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unlistableManager hides remote.Lister of the manager.
+type unlistableManager struct {
+	remote.Manager
+}
+
+// keyPrefixManager keeps caches under prefix, like remotes of which key prefix is configured.
+// With brokenList, List returns keys including prefix, which Load does not accept.
+type keyPrefixManager struct {
+	*remotetest.MemoryManager
+	prefix     string
+	brokenList bool
+}
+
+func (m keyPrefixManager) Load(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]remote.Candidate, error) {
+	prefixed := make([]string, 0, len(secondaryKeys))
+	for _, key := range secondaryKeys {
+		prefixed = append(prefixed, m.prefix+key)
+	}
+	candidates, err := m.MemoryManager.Load(ctx, m.prefix+primaryKey, prefixed)
+	for i := range candidates {
+		candidates[i].Key = strings.TrimPrefix(candidates[i].Key, m.prefix)
+	}
+	return candidates, err
+}
+
+func (m keyPrefixManager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	entries, err := m.MemoryManager.List(ctx, m.prefix+prefix)
+	if !m.brokenList {
+		for i := range entries {
+			entries[i].Key = strings.TrimPrefix(entries[i].Key, m.prefix)
+		}
+	}
+	return entries, err
+}
+
+func (m keyPrefixManager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	sizeHint int64,
+	metadata remote.Metadata,
+) error {
+	return m.MemoryManager.Save(ctx, m.prefix+cacheKey, data, sizeHint, metadata)
+}
+
+func TestCopyBetweenRemotes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		inputs        map[string]string
+		unlistable    bool
+		expectedError bool
+		expectedData  map[string]string
+	}{
+		{
+			name:         "copy",
+			expectedData: map[string]string{"a-1": "src-a-1", "a-2": "src-a-2", "a-3": "dst-a-3"},
+		},
+		{
+			name:         "rewrite",
+			inputs:       map[string]string{InputRewriteCache: "true"},
+			expectedData: map[string]string{"a-1": "src-a-1", "a-2": "src-a-2", "a-3": "src-a-3"},
+		},
+		{
+			name:         "dry run",
+			inputs:       map[string]string{InputDryRun: "true"},
+			expectedData: map[string]string{"a-3": "dst-a-3"},
+		},
+		{
+			name:          "unlistable source",
+			unlistable:    true,
+			expectedError: true,
+			expectedData:  map[string]string{"a-3": "dst-a-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			srcMemory := remotetest.NewMemoryManager()
+			for _, key := range []string{"a-2", "b-1", "a-1", "a-3"} {
+				err := srcMemory.Save(ctx, key, strings.NewReader("src-"+key), 0, remote.Metadata{"codec": key})
+				require.NoError(t, err)
+			}
+			var src remote.Manager = srcMemory
+			if tt.unlistable {
+				src = unlistableManager{srcMemory}
+			}
+			dst := remotetest.NewMemoryManager()
+			require.NoError(t, dst.Save(ctx, "a-3", strings.NewReader("dst-a-3"), 0, remote.Metadata{"codec": "a-3"}))
+
+			err := CopyBetweenRemotes(ctx, newTestAction(t, tt.inputs, nil), src, dst, "a-")
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			data := make(map[string]string)
+			for _, key := range dst.Keys() {
+				candidates, err := dst.Load(ctx, key, nil)
+				require.NoError(t, err)
+				require.Len(t, candidates, 1)
+				loaded, err := candidates[0].Open(ctx)
+				require.NoError(t, err)
+				raw, err := io.ReadAll(loaded.Data)
+				require.NoError(t, err)
+				data[key] = string(raw)
+				assert.Equal(t, remote.Metadata{"codec": key}, loaded.Metadata)
+			}
+			assert.Equal(t, tt.expectedData, data)
+		})
+	}
+}
+
+func TestCopyBetweenRemotes_PreservesOrder(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src := remotetest.NewMemoryManager()
+	for _, key := range []string{"key-new", "key-old"} {
+		require.NoError(t, src.Save(ctx, key, strings.NewReader(key), 0, nil))
+	}
+	dst := remotetest.NewMemoryManager()
+
+	require.NoError(t, CopyBetweenRemotes(ctx, newTestAction(t, nil, nil), src, dst, ""))
+
+	// key-old is saved later than key-new in src, so it is resolved first in both
+	expected, err := src.Load(ctx, "key", []string{"key-"})
+	require.NoError(t, err)
+	actual, err := dst.Load(ctx, "key", []string{"key-"})
+	require.NoError(t, err)
+	assert.Equal(t, remotetest.Keys(expected), remotetest.Keys(actual))
+}
+
+func TestCopyBetweenRemotes_KeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		brokenList    bool
+		expectedError bool
+		expectedKeys  []string
+	}{
+		{
+			name:         "keys relative to key prefix",
+			expectedKeys: []string{"a-1", "a-2"},
+		},
+		{
+			name:          "listed but not loadable",
+			brokenList:    true,
+			expectedError: true,
+			expectedKeys:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			src := keyPrefixManager{MemoryManager: remotetest.NewMemoryManager(), prefix: "prefixed/", brokenList: tt.brokenList}
+			for _, key := range []string{"a-1", "a-2"} {
+				require.NoError(t, src.Save(ctx, key, strings.NewReader(key), 0, nil))
+			}
+			dst := remotetest.NewMemoryManager()
+
+			err := CopyBetweenRemotes(ctx, newTestAction(t, nil, nil), src, dst, "a-")
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.ElementsMatch(t, tt.expectedKeys, dst.Keys())
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+// RetentionPolicy decides which caches whose key starts with Prefix are kept.
+// A cache is deleted if it exceeds any of limits. Zero limits are not applied.
+type RetentionPolicy struct {
+	Prefix string
+	// KeepLast is the number of the newest caches to keep.
+	KeepLast int
+	// MaxAge is the age of the oldest cache to keep.
+	MaxAge time.Duration
+	// MaxTotalSize is the sum of sizes of caches to keep in bytes, filled from the newest cache.
+	MaxTotalSize int64
+}
+
+// ParseRetentionPolicy parses comma separated fields (e.g. `prefix=Linux-buildkit_state-,keep=5,max-age=7d`).
+// Fields are `prefix`, `keep`, `max-age` (duration with `d` for days) and `max-size` (e.g. `10GiB`).
+func ParseRetentionPolicy(raw string) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	for _, field := range strings.Split(raw, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return RetentionPolicy{}, errors.Errorf("invalid field of retention policy: %q", field)
+		}
+
+		var err error
+		switch name {
+		case "prefix":
+			policy.Prefix = value
+		case "keep":
+			policy.KeepLast, err = strconv.Atoi(value)
+		case "max-age":
+			policy.MaxAge, err = parseAge(value)
+		case "max-size":
+			policy.MaxTotalSize, err = units.RAMInBytes(value)
+		default:
+			return RetentionPolicy{}, errors.Errorf("unknown field of retention policy: %q", name)
+		}
+		if err != nil {
+			return RetentionPolicy{}, errors.Wrapf(err, "invalid %s of retention policy", name)
+		}
+	}
+
+	if policy.KeepLast < 0 || policy.MaxAge < 0 || policy.MaxTotalSize < 0 {
+		return RetentionPolicy{}, errors.Errorf("limits of retention policy must not be negative: %q", raw)
+	}
+	if policy.KeepLast == 0 && policy.MaxAge == 0 && policy.MaxTotalSize == 0 {
+		return RetentionPolicy{}, errors.Errorf("retention policy has no limit: %q", raw)
+	}
+	return policy, nil
+}
+
+// parseAge extends time.ParseDuration with days, because caches usually live for days.
+func parseAge(raw string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(raw, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(raw)
+	return duration, errors.WithStack(err)
+}
+
+// SelectExpired returns entries that policies do not keep, from the newest one.
+// Each entry follows the policy of the longest matching prefix, and entries that no policy matches are kept.
+// Sizes of entries are counted as zero if unknown.
+func SelectExpired(entries []remote.Entry, policies []RetentionPolicy, now time.Time) []remote.Entry {
+	sorted := slices.Clone(entries)
+	slices.SortFunc(sorted, func(a, b remote.Entry) bool {
+		if !a.Modified.Equal(b.Modified) {
+			return a.Modified.After(b.Modified)
+		}
+		return a.Key < b.Key
+	})
+
+	type usage struct {
+		kept      int
+		totalSize int64
+		exhausted bool
+	}
+	usages := make([]usage, len(policies))
+
+	var expired []remote.Entry
+	for _, entry := range sorted {
+		matched := -1
+		for i, policy := range policies {
+			if strings.HasPrefix(entry.Key, policy.Prefix) &&
+				(matched < 0 || len(policy.Prefix) > len(policies[matched].Prefix)) {
+				matched = i
+			}
+		}
+		if matched < 0 {
+			continue
+		}
+
+		policy, used := policies[matched], &usages[matched]
+		size := entry.Size
+		if size < 0 {
+			size = 0
+		}
+		// once the size limit is reached, older caches are not kept even if they are small enough to fit
+		used.exhausted = used.exhausted || (policy.MaxTotalSize > 0 && used.totalSize+size > policy.MaxTotalSize)
+
+		switch {
+		case policy.KeepLast > 0 && used.kept >= policy.KeepLast,
+			policy.MaxAge > 0 && now.Sub(entry.Modified) > policy.MaxAge,
+			used.exhausted:
+			expired = append(expired, entry)
+		default:
+			used.kept++
+			used.totalSize += size
+		}
+	}
+	return expired
+}
+
+// CollectGarbage deletes caches that are not kept by retention policies of `retention` input.
+// The cache of `cache-key` input is never deleted, so that it can run right after saving the cache,
+// including the ones of each node of nodes that the cache is saved from. nodes can be nil if it is not known.
+// With `dry-run` input, it only reports caches to be deleted.
+func CollectGarbage(ctx context.Context, runner ci.CI, nodes []buildkit.Node, manager remote.Manager) (err error) {
+	defer func() {
+		if err != nil {
+			runner.Errorf("Failed to collect garbage of remote: %+v", err)
+		}
+	}()
+
+	var policies []RetentionPolicy
+	for _, raw := range ci.GetMultilineInput(runner, InputRetention) {
+		policy, err := ParseRetentionPolicy(raw)
+		if err != nil {
+			return err
+		}
+		policies = append(policies, policy)
+	}
+	if len(policies) == 0 {
+		return errors.Errorf(`"%s" is required to collect garbage`, InputRetention)
+	}
+
+	dryRun, err := parseOptionalBool(runner, InputDryRun)
+	if err != nil {
+		return err
+	}
+
+	lister, ok := manager.(remote.Lister)
+	if !ok {
+		return errors.New("remote does not support listing")
+	}
+	deleter, ok := manager.(remote.Deleter)
+	if !ok && !dryRun {
+		return errors.New("remote does not support deletion")
+	}
+
+	runner.Group("Collect garbage of remote")
+	defer runner.EndGroup()
+
+	entries, err := lister.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	var protectedKeys []string
+	if cacheKey := runner.GetInput(InputPrimaryKey); cacheKey != "" {
+		protectedKeys = append(protectedKeys, cacheKey)
+		for _, node := range nodes {
+			protectedKeys = append(protectedKeys, newNodeScope(nodes, node).key(cacheKey))
+		}
+	}
+
+	// the same key is listed once per branch if the remote keeps a cache per branch (e.g. GitHub Actions),
+	// but deletion removes all of them. So a key is deleted only if none of its entries is kept.
+	entriesOfKey := make(map[string]int)
+	for _, entry := range entries {
+		entriesOfKey[entry.Key]++
+	}
+	expired := SelectExpired(entries, policies, time.Now())
+	for _, entry := range expired {
+		entriesOfKey[entry.Key]--
+	}
+
+	var deletedKeys []string
+	var freed int64
+	for _, entry := range expired {
+		if slices.Contains(protectedKeys, entry.Key) || slices.Contains(deletedKeys, entry.Key) {
+			continue
+		}
+		if entriesOfKey[entry.Key] > 0 {
+			runner.Infof("Keeping %s because other entry of the same key is kept", entry.Key)
+			continue
+		}
+
+		size := "unknown size"
+		if entry.Size >= 0 {
+			size = units.HumanSize(float64(entry.Size))
+			freed += entry.Size
+		}
+		if dryRun {
+			runner.Infof("Would delete %s (%s, saved at %s)", entry.Key, size, entry.Modified.Format(time.RFC3339))
+		} else {
+			runner.Infof("Deleting %s (%s, saved at %s)", entry.Key, size, entry.Modified.Format(time.RFC3339))
+			if err = deleter.Delete(ctx, entry.Key); err != nil {
+				return err
+			}
+		}
+		deletedKeys = append(deletedKeys, entry.Key)
+	}
+
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	runner.Infof("%s %d of %d caches, %s", verb, len(deletedKeys), len(entries), units.HumanSize(float64(freed)))
+	return nil
+}
@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		raw           string
+		expected      RetentionPolicy
+		expectedError bool
+	}{
+		{
+			raw:      "prefix=Linux-buildkit_state-,keep=5",
+			expected: RetentionPolicy{Prefix: "Linux-buildkit_state-", KeepLast: 5},
+		},
+		{
+			raw:      "max-age=7d, max-size=10GiB",
+			expected: RetentionPolicy{MaxAge: 7 * 24 * time.Hour, MaxTotalSize: 10 << 30},
+		},
+		{
+			raw:      "prefix=a-,max-age=36h",
+			expected: RetentionPolicy{Prefix: "a-", MaxAge: 36 * time.Hour},
+		},
+		{raw: "prefix=a-", expectedError: true},
+		{raw: "keep=-1", expectedError: true},
+		{raw: "keep=many", expectedError: true},
+		{raw: "max-age=week", expectedError: true},
+		{raw: "keep", expectedError: true},
+		{raw: "count=3", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.raw, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := ParseRetentionPolicy(tt.raw)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, policy)
+		})
+	}
+}
+
+func TestSelectExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 7, 10, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	entries := []remote.Entry{
+		{Key: "a-1", Size: 100, Modified: now.Add(-5 * day)},
+		{Key: "a-2", Size: 100, Modified: now.Add(-3 * day)},
+		{Key: "a-3", Size: 100, Modified: now.Add(-1 * day)},
+		{Key: "a-main-1", Size: 100, Modified: now.Add(-4 * day)},
+		{Key: "b-1", Size: -1, Modified: now.Add(-2 * day)},
+		{Key: "b-2", Size: -1, Modified: now.Add(-2 * day)},
+	}
+
+	tests := []struct {
+		name         string
+		policies     []RetentionPolicy
+		expectedKeys []string
+	}{
+		{
+			name:         "keep last",
+			policies:     []RetentionPolicy{{Prefix: "a-", KeepLast: 2}},
+			expectedKeys: []string{"a-main-1", "a-1"},
+		},
+		{
+			name:         "max age",
+			policies:     []RetentionPolicy{{MaxAge: 3 * day}},
+			expectedKeys: []string{"a-main-1", "a-1"},
+		},
+		{
+			name:         "max total size",
+			policies:     []RetentionPolicy{{Prefix: "a-", MaxTotalSize: 250}},
+			expectedKeys: []string{"a-main-1", "a-1"},
+		},
+		{
+			name:         "unknown size",
+			policies:     []RetentionPolicy{{Prefix: "b-", MaxTotalSize: 1}},
+			expectedKeys: nil,
+		},
+		{
+			name:         "tie broken by key",
+			policies:     []RetentionPolicy{{Prefix: "b-", KeepLast: 1}},
+			expectedKeys: []string{"b-2"},
+		},
+		{
+			name:         "longest prefix",
+			policies:     []RetentionPolicy{{Prefix: "a-", KeepLast: 1}, {Prefix: "a-main-", KeepLast: 1}},
+			expectedKeys: []string{"a-2", "a-1"},
+		},
+		{
+			name:         "combined limits",
+			policies:     []RetentionPolicy{{Prefix: "a-", KeepLast: 3, MaxAge: 4*day + time.Hour}},
+			expectedKeys: []string{"a-1"},
+		},
+		{
+			name:         "no policy",
+			policies:     nil,
+			expectedKeys: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var keys []string
+			for _, entry := range SelectExpired(entries, tt.policies, now) {
+				keys = append(keys, entry.Key)
+			}
+			assert.Equal(t, tt.expectedKeys, keys)
+		})
+	}
+}
+
+// branchManager lists extra entries along with the ones of MemoryManager,
+// like remotes that keep a cache per branch under the same key.
+type branchManager struct {
+	*remotetest.MemoryManager
+	extra []remote.Entry
+}
+
+func (m branchManager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	entries, err := m.MemoryManager.List(ctx, prefix)
+	return append(entries, m.extra...), err
+}
+
+func TestCollectGarbage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		inputs        map[string]string
+		keys          []string
+		nodes         []buildkit.Node
+		extra         []remote.Entry
+		expectedError bool
+		expectedKeys  []string
+	}{
+		{
+			name:         "delete",
+			inputs:       map[string]string{InputRetention: "prefix=key-,keep=1"},
+			expectedKeys: []string{"key-3", "other"},
+		},
+		{
+			name:         "dry run",
+			inputs:       map[string]string{InputRetention: "prefix=key-,keep=1", InputDryRun: "true"},
+			expectedKeys: []string{"key-1", "key-2", "key-3", "other"},
+		},
+		{
+			name:         "protect cache key",
+			inputs:       map[string]string{InputRetention: "keep=1", InputPrimaryKey: "key-1"},
+			expectedKeys: []string{"key-1", "key-3"},
+		},
+		{
+			name:   "protect cache key of each node",
+			inputs: map[string]string{InputRetention: "keep=1", InputPrimaryKey: "key"},
+			keys:   []string{"linux-amd64_key", "linux-arm64_key", "linux-amd64_old", "other"},
+			nodes: []buildkit.Node{
+				{ID: "linux-amd64"},
+				{ID: "linux-arm64"},
+			},
+			expectedKeys: []string{"linux-amd64_key", "linux-arm64_key", "other"},
+		},
+		{
+			name:         "keep key that is kept in other branch",
+			inputs:       map[string]string{InputRetention: "prefix=key-,keep=1"},
+			extra:        []remote.Entry{{Key: "key-1", Size: 5, Modified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			expectedKeys: []string{"key-1", "other"},
+		},
+		{
+			name:         "delete key that is expired in all branches",
+			inputs:       map[string]string{InputRetention: "prefix=key-,keep=1"},
+			extra:        []remote.Entry{{Key: "key-1", Size: 5, Modified: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			expectedKeys: []string{"key-3", "other"},
+		},
+		{
+			name:          "without policy",
+			inputs:        map[string]string{InputRetention: ""},
+			expectedError: true,
+			expectedKeys:  []string{"key-1", "key-2", "key-3", "other"},
+		},
+		{
+			name:          "invalid policy",
+			inputs:        map[string]string{InputRetention: "prefix=key-,keep=1\nkeep=all"},
+			expectedError: true,
+			expectedKeys:  []string{"key-1", "key-2", "key-3", "other"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			keys := tt.keys
+			if keys == nil {
+				keys = []string{"key-1", "key-2", "other", "key-3"}
+			}
+			manager := remotetest.NewMemoryManager()
+			for _, key := range keys {
+				require.NoError(t, manager.Save(context.Background(), key, strings.NewReader(key), 0, nil))
+			}
+			runner := newTestAction(t, tt.inputs, nil)
+
+			err := CollectGarbage(context.Background(), runner, tt.nodes, branchManager{manager, tt.extra})
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.ElementsMatch(t, tt.expectedKeys, manager.Keys())
+		})
+	}
+}
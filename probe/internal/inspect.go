@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/moby/buildkit/cache/metadata"
+	pkgerrors "github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// metadataDBName is the metadata db of each worker (e.g. `buildkit/runc-overlayfs/metadata_v2.db`),
+	// which records cache mounts. Note that `buildkit/cache.db` is the one of the solver, not of cache mounts.
+	metadataDBName = "metadata_v2.db"
+
+	// keys of records in the metadata db of buildkit.
+	keyCacheDir    = "cache-dir"
+	keyDescription = "cache.description"
+	keySize        = "snapshot.size"
+	keyLastUsedAt  = "cache.lastUsedAt"
+	keyUsageCount  = "cache.usageCount"
+)
+
+// Inspection summarizes contents of a saved state.
+type Inspection struct {
+	Key string `json:"key"`
+	// CompressedSize is the size of stored data, and Size is the sum of sizes of regular files in it.
+	CompressedSize int64 `json:"compressed_size"`
+	Size           int64 `json:"size"`
+	Files          int64 `json:"files"`
+	Codec          Codec `json:"codec"`
+	// Verified reports whether checksum is recorded and matches. Mismatch fails Inspect.
+	Verified    bool             `json:"verified"`
+	Manifest    *remote.Manifest `json:"manifest,omitempty"`
+	Snapshots   []SnapshotUsage  `json:"snapshots"`
+	CacheMounts []CacheMount     `json:"cache_mounts"`
+}
+
+// SnapshotUsage is the usage of a snapshot directory of a snapshotter (e.g. runc-overlayfs).
+type SnapshotUsage struct {
+	Snapshotter string `json:"snapshotter"`
+	ID          string `json:"id"`
+	Files       int64  `json:"files"`
+	Size        int64  `json:"size"`
+}
+
+// CacheMount is a record of `RUN --mount=type=cache` in the metadata db of a worker of buildkit.
+type CacheMount struct {
+	// Worker is the directory of the worker (e.g. runc-overlayfs) that has the record.
+	Worker string `json:"worker"`
+	// ID is `id` of the mount, which defaults to its target path.
+	ID          string `json:"id"`
+	RecordID    string `json:"record_id"`
+	Description string `json:"description"`
+	// Size is the one that buildkit computed, or negative if buildkit has not computed it.
+	Size       int64     `json:"size"`
+	UsageCount int       `json:"usage_count"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// Inspect streams loaded through the decompressor and the tar reader, without extracting it into buildkitd.
+func Inspect(loaded remote.LoadedCache) (Inspection, error) {
+	inspection := Inspection{Key: loaded.Key, Snapshots: []SnapshotUsage{}, CacheMounts: []CacheMount{}}
+	if manifest, found := loaded.Manifest().Get(); found {
+		inspection.Manifest = &manifest
+	}
+	if err := CheckDecompressible(loaded.Metadata); err != nil {
+		return Inspection{}, err
+	}
+
+	hash := sha256.New()
+	compressed := &countingReader{reader: io.TeeReader(loaded.Data, hash)}
+	buffered := bufio.NewReader(compressed)
+	codec, err := detectCodec(buffered, loaded.Metadata)
+	if err != nil {
+		return Inspection{}, err
+	}
+	inspection.Codec = codec
+	windowSize, err := decoderWindowSize(loaded.Metadata, codec)
+	if err != nil {
+		return Inspection{}, err
+	}
+	contents, err := newDecoder(buffered, codec, windowSize)
+	if err != nil {
+		return Inspection{}, err
+	}
+	defer contents.Close()
+
+	dbDir, err := os.MkdirTemp("", "buildkit-state-*")
+	if err != nil {
+		return Inspection{}, pkgerrors.WithStack(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	var workers []string
+	reader := tar.NewReader(contents)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Inspection{}, pkgerrors.WithStack(err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		inspection.Files++
+		inspection.Size += header.Size
+		if snapshotter, id, found := snapshotOf(header.Name); found {
+			inspection.Snapshots = addSnapshotUsage(inspection.Snapshots, snapshotter, id, header.Size)
+		}
+		if worker, found := workerOf(header.Name); found {
+			if err = writeFile(filepath.Join(dbDir, worker), reader); err != nil {
+				return Inspection{}, err
+			}
+			workers = append(workers, worker)
+		}
+	}
+
+	// trailing data (e.g. the end of frame) has to be read to compute checksum of whole data
+	if _, err = io.Copy(io.Discard, buffered); err != nil {
+		return Inspection{}, pkgerrors.WithStack(err)
+	}
+	inspection.CompressedSize = compressed.n
+	if expected, found := loaded.Metadata[metadataChecksum]; found {
+		if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+			return Inspection{}, pkgerrors.Errorf("checksum mismatch: expected %s but got %s", expected, actual)
+		}
+		inspection.Verified = true
+	}
+
+	for _, worker := range workers {
+		mounts, err := readCacheMounts(filepath.Join(dbDir, worker), worker)
+		if err != nil {
+			return Inspection{}, err
+		}
+		inspection.CacheMounts = append(inspection.CacheMounts, mounts...)
+	}
+	slices.SortFunc(inspection.CacheMounts, func(a, b CacheMount) bool {
+		if a.Worker != b.Worker {
+			return a.Worker < b.Worker
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return a.RecordID < b.RecordID
+	})
+
+	slices.SortFunc(inspection.Snapshots, func(a, b SnapshotUsage) bool {
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		if a.Snapshotter != b.Snapshotter {
+			return a.Snapshotter < b.Snapshotter
+		}
+		return a.ID < b.ID
+	})
+	return inspection, nil
+}
+
+// snapshotOf parses name of archive member in `buildkit/<snapshotter>/snapshots/snapshots/<id>/...`,
+// which is the layout of snapshotters of containerd that buildkit uses.
+func snapshotOf(name string) (snapshotter, id string, found bool) {
+	parts := strings.Split(path.Clean(name), "/")
+	if len(parts) < 6 || parts[0] != archiveRoot || parts[2] != "snapshots" || parts[3] != "snapshots" {
+		return "", "", false
+	}
+	return parts[1], parts[4], true
+}
+
+// workerOf parses name of archive member in `buildkit/<worker>/metadata_v2.db`.
+func workerOf(name string) (worker string, found bool) {
+	parts := strings.Split(path.Clean(name), "/")
+	if len(parts) != 3 || parts[0] != archiveRoot || parts[2] != metadataDBName {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func writeFile(name string, data io.Reader) error {
+	fp, err := os.Create(name)
+	if err != nil {
+		return pkgerrors.WithStack(err)
+	}
+	if _, err = io.Copy(fp, data); err != nil {
+		_ = fp.Close()
+		return pkgerrors.WithStack(err)
+	}
+	return pkgerrors.WithStack(fp.Close())
+}
+
+func addSnapshotUsage(usages []SnapshotUsage, snapshotter, id string, size int64) []SnapshotUsage {
+	i := slices.IndexFunc(usages, func(u SnapshotUsage) bool { return u.Snapshotter == snapshotter && u.ID == id })
+	if i < 0 {
+		usages = append(usages, SnapshotUsage{Snapshotter: snapshotter, ID: id})
+		i = len(usages) - 1
+	}
+	usages[i].Files++
+	usages[i].Size += size
+	return usages
+}
+
+// readCacheMounts reads records that have cache mount id from the metadata db of worker.
+func readCacheMounts(dbPath, worker string) ([]CacheMount, error) {
+	store, err := metadata.NewStore(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	items, err := store.All()
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := []CacheMount{}
+	for _, item := range items {
+		mount := CacheMount{Worker: worker}
+		if !unmarshalValue(item, keyCacheDir, &mount.ID) {
+			continue
+		}
+		mount.RecordID = item.ID()
+		unmarshalValue(item, keyDescription, &mount.Description)
+		if !unmarshalValue(item, keySize, &mount.Size) {
+			mount.Size = -1
+		}
+		unmarshalValue(item, keyUsageCount, &mount.UsageCount)
+		var lastUsedAt int64
+		if unmarshalValue(item, keyLastUsedAt, &lastUsedAt) {
+			mount.LastUsedAt = time.Unix(0, lastUsedAt).UTC()
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
+
+// unmarshalValue reports whether item has a valid value of key.
+func unmarshalValue(item *metadata.StorageItem, key string, target any) bool {
+	value := item.Get(key)
+	return value != nil && value.Unmarshal(target) == nil
+}
+
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
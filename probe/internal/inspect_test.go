@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/moby/buildkit/cache/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestMetadataDB creates the metadata db of buildkit that has records of values.
+func newTestMetadataDB(t *testing.T, records map[string]map[string]any) []byte {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), metadataDBName)
+	store, err := metadata.NewStore(dbPath)
+	require.NoError(t, err)
+	for id, values := range records {
+		item, _ := store.Get(id)
+		require.NoError(t, item.Update(func(b *bolt.Bucket) error {
+			for key, value := range values {
+				v, err := metadata.NewValue(value)
+				if err != nil {
+					return err
+				}
+				if key == keyCacheDir {
+					v.Index = keyCacheDir + ":" + value.(string)
+				}
+				if err = item.SetValue(b, key, v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.NoError(t, store.Close())
+
+	db, err := os.ReadFile(dbPath)
+	require.NoError(t, err)
+	return db
+}
+
+func newTestArchive(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	writer := tar.NewWriter(buf)
+	require.NoError(t, writer.WriteHeader(&tar.Header{Name: archiveRoot + "/", Typeflag: tar.TypeDir, Mode: 0o700}))
+	for name, data := range files {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o600,
+			Size:     int64(len(data)),
+		}))
+		_, err := writer.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.WriteHeader(&tar.Header{
+		Name:     archiveRoot + "/runc-overlayfs/snapshots/snapshots/1/fs/link",
+		Typeflag: tar.TypeSymlink,
+		Linkname: "a",
+	}))
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+
+	lastUsedAt := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	runcDB := newTestMetadataDB(t, map[string]map[string]any{
+		"record-go": {
+			keyCacheDir:    "/root/go/pkg/mod",
+			keyDescription: "cached mount /root/go/pkg/mod from exec /bin/sh -c go mod download",
+			keySize:        int64(30),
+			keyUsageCount:  3,
+			keyLastUsedAt:  lastUsedAt.UnixNano(),
+		},
+		"record-apt": {
+			keyCacheDir: "apt",
+		},
+		"record-layer": {
+			keyDescription: "pulled from docker.io/library/alpine",
+		},
+	})
+	containerdDB := newTestMetadataDB(t, map[string]map[string]any{
+		"record-npm": {keyCacheDir: "/root/.npm", keySize: int64(5)},
+	})
+	// the cache db of the solver, which must not be taken for a metadata db of worker
+	solverDB := newTestMetadataDB(t, map[string]map[string]any{
+		"record-solver": {keyCacheDir: "solver"},
+	})
+	archive := newTestArchive(t, map[string][]byte{
+		archiveRoot + "/cache.db":                                   solverDB,
+		archiveRoot + "/runc-overlayfs/" + metadataDBName:           runcDB,
+		archiveRoot + "/containerd-overlayfs/" + metadataDBName:     containerdDB,
+		archiveRoot + "/runc-overlayfs/snapshots/metadata.db":       []byte("db"),
+		archiveRoot + "/runc-overlayfs/snapshots/snapshots/1/fs/a":  bytes.Repeat([]byte("a"), 10),
+		archiveRoot + "/runc-overlayfs/snapshots/snapshots/1/fs/b":  bytes.Repeat([]byte("b"), 20),
+		archiveRoot + "/runc-overlayfs/snapshots/snapshots/12/fs/c": bytes.Repeat([]byte("c"), 40),
+	})
+
+	manifest := remote.Manifest{BuildkitVersion: "v0.11.6", Snapshotter: "overlayfs", Platform: "linux/amd64"}
+	expected := Inspection{
+		Key:   "key",
+		Size:  int64(len(solverDB) + len(runcDB) + len(containerdDB) + 2 + 10 + 20 + 40),
+		Files: 7,
+		Snapshots: []SnapshotUsage{
+			{Snapshotter: "runc-overlayfs", ID: "12", Files: 1, Size: 40},
+			{Snapshotter: "runc-overlayfs", ID: "1", Files: 2, Size: 30},
+		},
+		CacheMounts: []CacheMount{
+			{Worker: "containerd-overlayfs", ID: "/root/.npm", RecordID: "record-npm", Size: 5},
+			{
+				Worker:      "runc-overlayfs",
+				ID:          "/root/go/pkg/mod",
+				RecordID:    "record-go",
+				Description: "cached mount /root/go/pkg/mod from exec /bin/sh -c go mod download",
+				Size:        30,
+				UsageCount:  3,
+				LastUsedAt:  lastUsedAt,
+			},
+			{Worker: "runc-overlayfs", ID: "apt", RecordID: "record-apt", Size: -1},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		codec            Codec
+		withChecksum     bool
+		withManifest     bool
+		corrupt          bool
+		expectedVerified bool
+		expectedError    bool
+	}{
+		{name: "zstd", codec: CodecZstd, withChecksum: true, withManifest: true, expectedVerified: true},
+		{name: "without checksum", codec: CodecGzip, withChecksum: false, expectedVerified: false},
+		{name: "checksum mismatch", codec: CodecLZ4, withChecksum: true, corrupt: true, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			compressed := new(bytes.Buffer)
+			opts := CompressOptions{Codec: tt.codec, Level: 1, WindowLog: DefaultWindowLog}
+			encoder, err := newEncoder(compressed, opts)
+			require.NoError(t, err)
+			_, err = encoder.Write(archive)
+			require.NoError(t, err)
+			require.NoError(t, encoder.Close())
+
+			meta := opts.Metadata()
+			if tt.withChecksum {
+				checksum := sha256.Sum256(compressed.Bytes())
+				meta[metadataChecksum] = hex.EncodeToString(checksum[:])
+				if tt.corrupt {
+					meta[metadataChecksum] = hex.EncodeToString(make([]byte, sha256.Size))
+				}
+			}
+			if tt.withManifest {
+				require.NoError(t, meta.SetManifest(manifest))
+			}
+
+			inspection, err := Inspect(remote.LoadedCache{
+				Key:      "key",
+				Data:     io.NopCloser(bytes.NewReader(compressed.Bytes())),
+				Metadata: meta,
+				Extra:    meta.Extra(),
+			})
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			want := expected
+			want.CompressedSize = int64(compressed.Len())
+			want.Codec = tt.codec
+			want.Verified = tt.expectedVerified
+			if tt.withManifest {
+				want.Manifest = &manifest
+			}
+			assert.Equal(t, want, inspection)
+		})
+	}
+}
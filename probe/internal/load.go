@@ -2,126 +2,282 @@ package internal
 
 import (
 	"context"
+	"io"
 	"strconv"
 
 	"github.com/isac322/buildkit-state/probe/internal/buildkit"
-	gha2 "github.com/isac322/buildkit-state/probe/internal/gha"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
 	"github.com/isac322/buildkit-state/probe/internal/remote"
 
 	"github.com/pkg/errors"
 	"github.com/samber/mo"
-	"github.com/sethvargo/go-githubactions"
 )
 
 func LoadFromRemoteToContainer(
 	ctx context.Context,
-	gha *githubactions.Action,
-	bkCli buildkit.Driver,
+	runner ci.CI,
+	nodes []buildkit.Node,
 	manager remote.Manager,
 ) (err error) {
 	defer func() {
 		if err != nil {
-			gha.Errorf("Failed to load/save buildkit state: %+v", err)
+			runner.Errorf("Failed to load/save buildkit state: %+v", err)
 		}
 	}()
 
-	if gha.Getenv("RUNNER_DEBUG") == "1" {
+	for _, node := range nodes {
+		err = loadToNode(ctx, runner, newNodeScope(nodes, node), node, manager)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadToNode(
+	ctx context.Context,
+	runner ci.CI,
+	scope nodeScope,
+	bkCli buildkit.Driver,
+	manager remote.Manager,
+) (err error) {
+	// the state may be left by an earlier job that shares the state file (e.g. on a persistent agent),
+	// which would make save skip the cache as if it is restored by this job.
+	if runner.GetState(scope.name(stateLoadedCacheKey)) != "" {
+		runner.SaveState(scope.name(stateLoadedCacheKey), "")
+	}
+
+	if runner.IsDebug() {
 		usage, err := bkCli.PrintDiskUsage(ctx)
 		if err != nil {
-			gha.Errorf("Failed to print disk usage: %+v", err)
+			runner.Errorf("Failed to print disk usage: %+v", err)
 			return err
 		}
 
-		gha.Debugf(string(usage))
+		runner.Debugf(string(usage))
 	}
 
-	var loaded remote.LoadedCache
-	var found bool
+	var info buildkit.DaemonInfo
+	var candidates []remote.Candidate
 
 	func() {
-		gha.Group("Load cache from remote")
-		defer gha.EndGroup()
+		runner.Group(scope.title("Load cache from remote"))
+		defer runner.EndGroup()
 
-		primaryKey := gha.GetInput(inputPrimaryKey)
-		gha.Debugf("primary key: %v", primaryKey)
-		secondaryKeys := gha2.GetMultilineInput(gha, inputSecondaryKeys)
-		gha.Debugf("secondary keys: %v", secondaryKeys)
+		primaryKey := scope.key(runner.GetInput(InputPrimaryKey))
+		runner.Debugf("primary key: %v", primaryKey)
+		secondaryKeys := scope.keys(ci.GetMultilineInput(runner, InputSecondaryKeys))
+		runner.Debugf("secondary keys: %v", secondaryKeys)
 
-		var cache mo.Option[remote.LoadedCache]
-		cache, err = manager.Load(ctx, primaryKey, secondaryKeys)
+		info, err = bkCli.DaemonInfo(ctx)
 		if err != nil {
-			gha.Errorf("Failed to load cache from remote: %+v", err)
+			runner.Errorf("Failed to query buildkitd: %+v", err)
 			return
 		}
 
-		loaded, found = cache.Get()
-		if !found {
-			gha.Infof("Can not find cache.\nskip state loading.")
+		candidates, err = manager.Load(ctx, primaryKey, secondaryKeys)
+		if err != nil {
+			runner.Errorf("Failed to load cache from remote: %+v", err)
 			return
 		}
-		gha.Infof("found cache from key: %v", loaded.Key)
-		gha.SetOutput(outputRestoredCacheKey, loaded.Key)
+		if len(candidates) == 0 {
+			runner.Infof("Can not find cache.\nskip state loading.")
+			return
+		}
+		for _, candidate := range candidates {
+			runner.Infof("found cache from key: %v", candidate.Key)
+		}
 	}()
 	if err != nil {
 		return err
 	}
-	if !found {
+	if len(candidates) == 0 {
 		return nil
 	}
 
-	gha.SaveState(stateLoadedCacheKey, loaded.Key)
+	maxAttempts := DefaultMaxRestoreAttempts
+	if rawMaxAttempts := runner.GetInput(InputMaxRestoreAttempts); rawMaxAttempts != "" {
+		maxAttempts, err = strconv.Atoi(rawMaxAttempts)
+		if err != nil {
+			runner.Errorf(`Failed to parse "%s": %+v`, InputMaxRestoreAttempts, err)
+			return errors.WithStack(err)
+		}
+	}
 
+	var restored mo.Option[string]
 	func() {
-		gha.Group("Load cache to docker")
-		defer gha.EndGroup()
+		runner.Group(scope.title("Load cache to docker"))
+		defer runner.EndGroup()
 
-		gha.Infof("stopping buildkitd...")
-		err = bkCli.Stop(ctx)
+		var stateDir string
+		stateDir, err = resolveStateDir(ctx, runner, bkCli)
 		if err != nil {
-			gha.Errorf("Failed to stop buildkitd container: %+v", err)
 			return
 		}
 
-		gha.Infof("restoring cache into buildkitd...")
-		err = DecompressZstdTo(ctx, bkCli, loaded.Data)
+		restored, err = restoreCandidates(ctx, runner, bkCli, stateDir, info, candidates, maxAttempts)
 		if err != nil {
-			gha.Errorf("Failed to restore cache into buildkitd: %+v", err)
-			return
+			runner.Errorf("Failed to restore cache into buildkitd: %+v", err)
 		}
 	}()
 	if err != nil {
 		return err
 	}
+	restoredKey, found := restored.Get()
+	if !found {
+		runner.Infof("Can not find compatible cache.\nskip state loading.")
+		return nil
+	}
+	runner.SetOutput(scope.name(outputRestoredCacheKey), scope.unqualifyKey(restoredKey))
+	runner.SaveState(scope.name(stateLoadedCacheKey), restoredKey)
+
+	return resumeBuilder(ctx, runner, scope, bkCli)
+}
 
-	resumeBuildkitD, err := strconv.ParseBool(gha.GetInput(inputResumeBuilder))
+// resumeBuilder starts buildkitd and prints its disk usage, unless `resume-builder` input is false.
+func resumeBuilder(ctx context.Context, runner ci.CI, scope nodeScope, bkCli buildkit.Driver) error {
+	resumeBuildkitD, err := strconv.ParseBool(runner.GetInput(InputResumeBuilder))
 	if err != nil {
-		gha.Errorf(`Failed to parse "%s": %+v`, inputResumeBuilder, err)
+		runner.Errorf(`Failed to parse "%s": %+v`, InputResumeBuilder, err)
 		return errors.WithStack(err)
 	}
 	if !resumeBuildkitD {
-		gha.Debugf("Skip resuming")
+		runner.Debugf("Skip resuming")
 		return nil
 	}
 
-	func() {
-		gha.Group("Resume buildkitd")
-		defer gha.EndGroup()
+	runner.Group(scope.title("Resume buildkitd"))
+	defer runner.EndGroup()
 
-		gha.Infof("starting buildkitd...")
-		err = bkCli.Resume(ctx)
-		if err != nil {
-			gha.Errorf("Failed to resume buildkitd container: %+v", err)
-			return
+	runner.Infof("starting buildkitd...")
+	if err = bkCli.Resume(ctx); err != nil {
+		runner.Errorf("Failed to resume buildkitd container: %+v", err)
+		return err
+	}
+
+	usage, err := bkCli.PrintDiskUsage(ctx)
+	if err != nil {
+		runner.Errorf("Failed to print disk usage: %+v", err)
+		return err
+	}
+	runner.Infof(string(usage))
+	return nil
+}
+
+// restoreCandidates tries candidates in the resolved order until one of them is restored, up to maxAttempts.
+// Fallback moves on to the next candidate rather than matching keys again, so no restore key is skipped or retried.
+// Incompatible candidates are skipped without touching buildkitd, and they are not reported as error.
+func restoreCandidates(
+	ctx context.Context,
+	runner ci.CI,
+	bkCli buildkit.Driver,
+	stateDir string,
+	info buildkit.DaemonInfo,
+	candidates []remote.Candidate,
+	maxAttempts int,
+) (mo.Option[string], error) {
+	if len(candidates) > maxAttempts {
+		runner.Warningf("Only %d of %d caches are tried.", maxAttempts, len(candidates))
+		candidates = candidates[:maxAttempts]
+	}
+
+	var lastErr error
+	for i, candidate := range candidates {
+		runner.Infof("restoring cache %s (%d/%d)...", candidate.Key, i+1, len(candidates))
+		err := restoreCandidate(ctx, runner, bkCli, stateDir, info, candidate)
+		if err == nil {
+			return mo.Some(candidate.Key), nil
 		}
 
-		var usage []byte
-		usage, err = bkCli.PrintDiskUsage(ctx)
-		if err != nil {
-			gha.Errorf("Failed to print disk usage: %+v", err)
-			return
+		if errors.Is(err, errIncompatible) {
+			runner.Warningf("Skip cache %s: %v", candidate.Key, err)
+			continue
 		}
-		gha.Infof(string(usage))
-	}()
+		runner.Warningf("Failed to restore cache %s: %+v", candidate.Key, err)
+		lastErr = err
+	}
+
+	return mo.None[string](), lastErr
+}
+
+func restoreCandidate(
+	ctx context.Context,
+	runner ci.CI,
+	bkCli buildkit.Driver,
+	stateDir string,
+	info buildkit.DaemonInfo,
+	candidate remote.Candidate,
+) error {
+	loaded, err := candidate.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer loaded.Data.Close()
+
+	if manifest, found := loaded.Manifest().Get(); found {
+		if err = checkCompatibility(manifest, info); err != nil {
+			return err
+		}
+	} else {
+		runner.Warningf("Cache %s does not have manifest, so its compatibility is not checked.", loaded.Key)
+	}
+	if err = CheckDecompressible(loaded.Metadata); err != nil {
+		return err
+	}
+
+	runner.Infof("stopping buildkitd...")
+	if err = bkCli.Stop(ctx); err != nil {
+		return err
+	}
+
+	runner.Infof("downloading, verifying and extracting cache into buildkitd...")
+	verified, err := restoreStaged(ctx, bkCli, stateDir, loaded.Data, loaded.Metadata)
+	if err != nil {
+		rollback(ctx, runner, bkCli, newStagedRestore(bkCli, stateDir))
+		return err
+	}
+	if !verified {
+		runner.Warningf("Cache %s does not have checksum, so its integrity is not verified.", loaded.Key)
+	}
+	return nil
+}
 
-	return err
+// restoreStaged extracts state into the staging directory while verifying its checksum,
+// and swaps it in only if the whole extraction succeeded and the checksum matched.
+func restoreStaged(
+	ctx context.Context,
+	bkCli buildkit.Driver,
+	stateDir string,
+	body io.Reader,
+	metadata remote.Metadata,
+) (verified bool, err error) {
+	staged := newStagedRestore(bkCli, stateDir)
+	if err = staged.prepare(ctx); err != nil {
+		return false, err
+	}
+	checksum := newChecksumReader(body)
+	if err = Decompress(ctx, bkCli, staged.stagingDir(), checksum, metadata); err != nil {
+		return false, err
+	}
+	if verified, err = checksum.verify(metadata); err != nil {
+		return false, err
+	}
+	return verified, staged.commit(ctx)
+}
+
+// rollback recovers the previous state and restarts buildkitd regardless of `resume-builder` input,
+// so that failure of restoring does not leave the builder unusable.
+func rollback(ctx context.Context, runner ci.CI, bkCli buildkit.Driver, staged stagedRestore) {
+	runner.Warningf("Rolling back to the previous state of buildkitd...")
+	if err := staged.rollback(ctx); err != nil {
+		runner.Errorf("Failed to roll back buildkit state: %+v", err)
+	} else {
+		runner.Infof("rolled back to the previous state")
+	}
+
+	runner.Infof("starting buildkitd...")
+	if err := bkCli.Resume(ctx); err != nil {
+		runner.Errorf("Failed to resume buildkitd container: %+v", err)
+	}
 }
@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/pkg/errors"
+	"github.com/samber/mo"
+	"github.com/sethvargo/go-githubactions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restoringDriver records restoring without buildkitd. The first failCopies extractions fail.
+type restoringDriver struct {
+	buildkit.Driver
+	failCopies int
+	copies     int
+	commits    int
+	stops      int
+	resumes    int
+}
+
+func (d *restoringDriver) Stop(context.Context) error {
+	d.stops++
+	return nil
+}
+
+func (d *restoringDriver) Resume(context.Context) error {
+	d.resumes++
+	return nil
+}
+
+func (d *restoringDriver) Shell(_ context.Context, script string) error {
+	// only commit of stagedRestore marks swapping
+	if strings.Contains(script, "touch "+swappingMarkName) {
+		d.commits++
+	}
+	return nil
+}
+
+func (d *restoringDriver) CopyTo(_ context.Context, _ string, content io.Reader) error {
+	d.copies++
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return err
+	}
+	if d.copies <= d.failCopies {
+		return errors.New("disk is full")
+	}
+	return nil
+}
+
+type testCandidate struct {
+	key      string
+	manifest remote.Manifest
+	corrupt  bool
+}
+
+func newTestCandidates(t *testing.T, opened *[]string, specs ...testCandidate) []remote.Candidate {
+	t.Helper()
+
+	archive := new(bytes.Buffer)
+	tw := tar.NewWriter(archive)
+	content := []byte("cache")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: archiveRoot + "/cache.db", Mode: 0o600, Size: 5}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	checksum := sha256.Sum256(archive.Bytes())
+
+	candidates := make([]remote.Candidate, 0, len(specs))
+	for _, spec := range specs {
+		spec := spec
+		metadata := CompressOptions{Codec: CodecNone}.Metadata()
+		metadata[metadataChecksum] = hex.EncodeToString(checksum[:])
+		require.NoError(t, metadata.SetManifest(spec.manifest))
+
+		data := archive.Bytes()
+		if spec.corrupt {
+			// the archive is still valid, so only checksum can tell the corruption
+			data = bytes.Replace(data, content, []byte("CACHE"), 1)
+		}
+		candidates = append(candidates, remote.NewCandidate(
+			spec.key,
+			time.Time{},
+			func(context.Context) (remote.LoadedCache, error) {
+				*opened = append(*opened, spec.key)
+				return remote.LoadedCache{
+					Key:      spec.key,
+					Data:     io.NopCloser(bytes.NewReader(data)),
+					Metadata: metadata,
+					Extra:    metadata.Extra(),
+				}, nil
+			},
+		))
+	}
+	return candidates
+}
+
+func TestRestoreCandidates(t *testing.T) {
+	t.Parallel()
+
+	compatible := newManifest(testDaemonInfo, nil)
+	incompatible := compatible
+	incompatible.BuildkitVersion = "v0.11.6"
+
+	tests := []struct {
+		name            string
+		candidates      []testCandidate
+		failCopies      int
+		maxAttempts     int
+		expectedKey     mo.Option[string]
+		expectedError   bool
+		expectedOpened  []string
+		expectedResumes int
+	}{
+		{
+			name:           "first candidate",
+			candidates:     []testCandidate{{key: "a", manifest: compatible}, {key: "b", manifest: compatible}},
+			maxAttempts:    3,
+			expectedKey:    mo.Some("a"),
+			expectedOpened: []string{"a"},
+		},
+		{
+			name:           "skip incompatible",
+			candidates:     []testCandidate{{key: "a", manifest: incompatible}, {key: "b", manifest: compatible}},
+			maxAttempts:    3,
+			expectedKey:    mo.Some("b"),
+			expectedOpened: []string{"a", "b"},
+		},
+		{
+			name: "skip corrupted",
+			candidates: []testCandidate{
+				{key: "a", manifest: compatible, corrupt: true},
+				{key: "b", manifest: compatible},
+			},
+			maxAttempts:     3,
+			expectedKey:     mo.Some("b"),
+			expectedOpened:  []string{"a", "b"},
+			expectedResumes: 1,
+		},
+		{
+			name:            "roll back failed restore",
+			candidates:      []testCandidate{{key: "a", manifest: compatible}, {key: "b", manifest: compatible}},
+			failCopies:      1,
+			maxAttempts:     3,
+			expectedKey:     mo.Some("b"),
+			expectedOpened:  []string{"a", "b"},
+			expectedResumes: 1,
+		},
+		{
+			name:           "all incompatible",
+			candidates:     []testCandidate{{key: "a", manifest: incompatible}},
+			maxAttempts:    3,
+			expectedKey:    mo.None[string](),
+			expectedOpened: []string{"a"},
+		},
+		{
+			name: "give up after max attempts",
+			candidates: []testCandidate{
+				{key: "a", manifest: compatible, corrupt: true},
+				{key: "b", manifest: compatible, corrupt: true},
+				{key: "c", manifest: compatible},
+			},
+			maxAttempts:     2,
+			expectedKey:     mo.None[string](),
+			expectedError:   true,
+			expectedOpened:  []string{"a", "b"},
+			expectedResumes: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var opened []string
+			candidates := newTestCandidates(t, &opened, tt.candidates...)
+			driver := &restoringDriver{failCopies: tt.failCopies}
+			runner := ci.NewGitHub(githubactions.New(githubactions.WithWriter(io.Discard)))
+
+			restored, err := restoreCandidates(
+				context.Background(),
+				runner,
+				driver,
+				buildkit.DefaultStateDir,
+				testDaemonInfo,
+				candidates,
+				tt.maxAttempts,
+			)
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedKey, restored)
+			assert.Equal(t, tt.expectedOpened, opened)
+			assert.Equal(t, tt.expectedResumes, driver.resumes)
+			if tt.expectedKey.IsPresent() {
+				assert.Equal(t, 1, driver.commits)
+			} else {
+				assert.Zero(t, driver.commits, "corrupted cache must not be committed")
+			}
+		})
+	}
+}
+
+// Fallback follows the resolved candidates, even if the primary key is a prefix of caches matched by restore keys.
+func TestRestoreCandidates_FallbackOrder(t *testing.T) {
+	t.Parallel()
+
+	compatible := newManifest(testDaemonInfo, nil)
+	incompatible := compatible
+	incompatible.BuildkitVersion = "v0.11.6"
+
+	var opened []string
+	candidates := newTestCandidates(
+		t,
+		&opened,
+		testCandidate{key: "linux-main", manifest: incompatible},
+		testCandidate{key: "linux-main-1", manifest: compatible, corrupt: true},
+		testCandidate{key: "linux-feature-1", manifest: incompatible},
+		testCandidate{key: "linux-feature-2", manifest: compatible},
+	)
+	saved := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range candidates {
+		candidates[i].Modified = saved.Add(time.Duration(len(candidates)-i) * time.Hour)
+	}
+	resolved := remote.Resolve(candidates, "linux-main", []string{"linux-main-", "linux-"})
+	require.Equal(t, []string{"linux-main", "linux-main-1", "linux-feature-1", "linux-feature-2"}, remotetest.Keys(resolved))
+
+	driver := &restoringDriver{}
+	runner := ci.NewGitHub(githubactions.New(githubactions.WithWriter(io.Discard)))
+	restored, err := restoreCandidates(
+		context.Background(),
+		runner,
+		driver,
+		buildkit.DefaultStateDir,
+		testDaemonInfo,
+		resolved,
+		len(resolved),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, mo.Some("linux-feature-2"), restored)
+	assert.Equal(t, []string{"linux-main", "linux-main-1", "linux-feature-1", "linux-feature-2"}, opened)
+	assert.Equal(t, 1, driver.commits)
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/containerd/containerd/platforms"
+	pkgerrors "github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+// errIncompatible is the cause of errors of checkCompatibility.
+var errIncompatible = pkgerrors.New("incompatible cache")
+
+func newManifest(info buildkit.DaemonInfo, targetTypes []string) remote.Manifest {
+	return remote.Manifest{
+		BuildkitVersion: info.Version,
+		Snapshotter:     info.Snapshotter,
+		Platform:        info.Platform,
+		TargetTypes:     targetTypes,
+		CreatedAt:       time.Now().UTC(),
+		ProbeVersion:    probeVersion(),
+	}
+}
+
+// probeVersion is the module version when the probe is built by `go install`, otherwise `(devel)`.
+func probeVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// checkCompatibility checks whether the state described by manifest can be restored into buildkitd of info.
+// Unknown fields on either side are not compared, because they can not tell incompatibility.
+func checkCompatibility(manifest remote.Manifest, info buildkit.DaemonInfo) error {
+	if manifest.BuildkitVersion != "" && info.Version != "" &&
+		majorMinor(manifest.BuildkitVersion) != majorMinor(info.Version) {
+		return pkgerrors.Wrapf(
+			errIncompatible,
+			"buildkit version mismatch: saved from %s but buildkitd is %s",
+			manifest.BuildkitVersion,
+			info.Version,
+		)
+	}
+
+	if manifest.Snapshotter != "" && info.Snapshotter != "" && manifest.Snapshotter != info.Snapshotter {
+		return pkgerrors.Wrapf(
+			errIncompatible,
+			"snapshotter mismatch: saved from %s but buildkitd uses %s",
+			manifest.Snapshotter,
+			info.Snapshotter,
+		)
+	}
+
+	if manifest.Platform != "" && info.Platform != "" {
+		saved, err := platforms.Parse(manifest.Platform)
+		if err != nil {
+			return pkgerrors.Wrapf(err, "invalid platform in manifest: %s", manifest.Platform)
+		}
+		current, err := platforms.Parse(info.Platform)
+		if err != nil {
+			return pkgerrors.Wrapf(err, "invalid platform of buildkitd: %s", info.Platform)
+		}
+		if saved.OS != current.OS || saved.Architecture != current.Architecture {
+			return pkgerrors.Wrapf(
+				errIncompatible,
+				"platform mismatch: saved from %s but buildkitd runs on %s",
+				manifest.Platform,
+				info.Platform,
+			)
+		}
+	}
+
+	return nil
+}
+
+// majorMinor trims patch version, because buildkit keeps format of its state within a minor version.
+// Versions that are not semver (e.g. development builds) are compared as is.
+func majorMinor(version string) string {
+	if mm := semver.MajorMinor(version); mm != "" {
+		return mm
+	}
+	return version
+}
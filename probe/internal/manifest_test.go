@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDaemonInfo = buildkit.DaemonInfo{
+	Version:     "v0.12.3",
+	Snapshotter: "overlayfs",
+	Platform:    "linux/amd64",
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		manifest   remote.Manifest
+		compatible bool
+	}{
+		{
+			name:       "same",
+			manifest:   newManifest(testDaemonInfo, nil),
+			compatible: true,
+		},
+		{
+			name:       "different patch version",
+			manifest:   remote.Manifest{BuildkitVersion: "v0.12.0", Snapshotter: "overlayfs", Platform: "linux/amd64"},
+			compatible: true,
+		},
+		{
+			name:       "different minor version",
+			manifest:   remote.Manifest{BuildkitVersion: "v0.11.6", Snapshotter: "overlayfs", Platform: "linux/amd64"},
+			compatible: false,
+		},
+		{
+			name:       "different snapshotter",
+			manifest:   remote.Manifest{BuildkitVersion: "v0.12.3", Snapshotter: "native", Platform: "linux/amd64"},
+			compatible: false,
+		},
+		{
+			name:       "different architecture",
+			manifest:   remote.Manifest{BuildkitVersion: "v0.12.3", Snapshotter: "overlayfs", Platform: "linux/arm64"},
+			compatible: false,
+		},
+		{
+			name:       "unknown fields",
+			manifest:   remote.Manifest{},
+			compatible: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkCompatibility(tt.manifest, testDaemonInfo)
+			if tt.compatible {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, errIncompatible)
+			}
+		})
+	}
+}
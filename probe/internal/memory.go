@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// availableMemory returns the amount of memory in bytes that this process is able to allocate, or 0 if unknown.
+// It respects the memory limit of cgroup v2, because runners are often containers.
+func availableMemory() uint64 {
+	available := memAvailable()
+	if limit := cgroupMemoryLimit(); limit > 0 && (available == 0 || limit < available) {
+		available = limit
+	}
+	return available
+}
+
+func memAvailable() uint64 {
+	fp, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		// e.g. `MemAvailable:   12345678 kB`
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemAvailable:" && fields[2] == "kB" {
+			kib, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kib * 1024
+		}
+	}
+	return 0
+}
+
+func cgroupMemoryLimit() uint64 {
+	raw, err := os.ReadFile("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		return 0
+	}
+
+	// `max` means unlimited
+	limit, err := strconv.ParseUint(string(bytes.TrimSpace(raw)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return limit
+}
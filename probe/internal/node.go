@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+)
+
+// nodeScope qualifies cache keys, outputs and states by node, if the builder has multiple nodes.
+// Builder with single node uses them as is, so that existing caches are still compatible.
+type nodeScope struct {
+	id string
+}
+
+func newNodeScope(nodes []buildkit.Node, node buildkit.Node) nodeScope {
+	if len(nodes) == 1 {
+		return nodeScope{}
+	}
+	return nodeScope{node.ID}
+}
+
+// key qualifies cache key by node ID.
+// Node ID is prepended rather than appended, because restore keys are matched by prefix
+// and appended ID would let a node restore the state of other nodes.
+func (s nodeScope) key(key string) string {
+	if s.id == "" {
+		return key
+	}
+	return s.id + "_" + key
+}
+
+func (s nodeScope) keys(keys []string) []string {
+	qualified := make([]string, 0, len(keys))
+	for _, key := range keys {
+		qualified = append(qualified, s.key(key))
+	}
+	return qualified
+}
+
+func (s nodeScope) unqualifyKey(key string) string {
+	if s.id == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, s.id+"_")
+}
+
+// name qualifies name of output or state.
+func (s nodeScope) name(name string) string {
+	if s.id == "" {
+		return name
+	}
+	return name + "-" + s.id
+}
+
+func (s nodeScope) title(title string) string {
+	if s.id == "" {
+		return title
+	}
+	return title + " (" + s.id + ")"
+}
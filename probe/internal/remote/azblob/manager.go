@@ -0,0 +1,239 @@
+package azblobmanager
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	version = "v1"
+
+	defaultBlockSize   = 8 * 1024 * 1024
+	uploadConcurrency  = 4
+	downloadMaxRetries = 5
+)
+
+type Manager struct {
+	client    *container.Client
+	keyPrefix string
+}
+
+func New(client *container.Client, keyPrefix string) Manager {
+	return Manager{client, keyPrefix}
+}
+
+// NewFromConnectionString creates Manager that accesses containerName using account connection string.
+func NewFromConnectionString(connectionString, containerName, keyPrefix string) (Manager, error) {
+	client, err := container.NewClientFromConnectionString(connectionString, containerName, nil)
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+	return New(client, keyPrefix), nil
+}
+
+// NewFromSAS creates Manager that accesses containerName of accountURL (e.g. https://<account>.blob.core.windows.net)
+// using SAS token.
+func NewFromSAS(accountURL, containerName, sasToken, keyPrefix string) (Manager, error) {
+	containerURL, err := url.JoinPath(accountURL, containerName)
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+	client, err := container.NewClientWithNoCredential(
+		containerURL+"?"+strings.TrimPrefix(sasToken, "?"),
+		nil,
+	)
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+	return New(client, keyPrefix), nil
+}
+
+func (m Manager) Load(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]remote.Candidate, error) {
+	items, err := m.listMatches(ctx, primaryKey, secondaryKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]remote.Candidate, 0, len(items))
+	for _, item := range items {
+		item := item
+		var modified time.Time
+		if item.Properties.LastModified != nil {
+			modified = *item.Properties.LastModified
+		}
+		candidates = append(candidates, remote.NewCandidate(
+			m.relKey(*item.Name),
+			modified,
+			func(ctx context.Context) (remote.LoadedCache, error) {
+				return m.open(ctx, item)
+			},
+		))
+	}
+
+	return remote.Resolve(candidates, primaryKey, secondaryKeys), nil
+}
+
+func (m Manager) open(ctx context.Context, item *container.BlobItem) (remote.LoadedCache, error) {
+	resp, err := m.client.NewBlobClient(*item.Name).DownloadStream(ctx, &blob.DownloadStreamOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: item.Properties.ETag},
+		},
+	})
+	if err != nil {
+		return remote.LoadedCache{}, errors.WithStack(err)
+	}
+
+	metadata := toMetadata(resp.Metadata)
+	return remote.LoadedCache{
+		Key:      m.relKey(*item.Name),
+		Data:     resp.NewRetryReader(ctx, &blob.RetryReaderOptions{MaxRetries: downloadMaxRetries}),
+		Metadata: metadata,
+		Extra:    metadata.Extra(),
+	}, nil
+}
+
+// toMetadata lowercases keys, because keys of metadata are canonicalized as HTTP headers on the way back.
+func toMetadata(blobMetadata map[string]*string) remote.Metadata {
+	metadata := make(remote.Metadata, len(blobMetadata))
+	for key, value := range blobMetadata {
+		if value != nil {
+			metadata[strings.ToLower(key)] = *value
+		}
+	}
+	return metadata
+}
+
+// listMatches lists every blob whose name starts with one of keys.
+func (m Manager) listMatches(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]*container.BlobItem, error) {
+	keys := make([]string, 0, 1+len(secondaryKeys))
+	keys = append(keys, m.buildBlobName(primaryKey))
+	for _, key := range secondaryKeys {
+		keys = append(keys, m.buildBlobName(key))
+	}
+
+	var items []*container.BlobItem
+	for _, key := range keys {
+		key := key
+		pager := m.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &key})
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			for _, item := range page.Segment.BlobItems {
+				// a blob is listed multiple times if keys overlap
+				if !slices.ContainsFunc(items, func(i *container.BlobItem) bool { return *i.Name == *item.Name }) {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+
+	return items, nil
+}
+
+func (m Manager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	sizeHint int64,
+	metadata remote.Metadata,
+) error {
+	blockSize := int64(defaultBlockSize)
+	// grow block size so that estimated size fits in the limit of the number of blocks
+	if size := sizeHint / blockblob.MaxBlocks; size > blockSize {
+		blockSize = size + 1
+	}
+
+	blobMetadata := make(map[string]*string, len(metadata))
+	for key, value := range metadata {
+		blobMetadata[key] = to.Ptr(value)
+	}
+
+	_, err := m.client.NewBlockBlobClient(m.buildBlobName(cacheKey)).UploadStream(
+		ctx,
+		data,
+		&blockblob.UploadStreamOptions{
+			BlockSize:   blockSize,
+			Concurrency: uploadConcurrency,
+			Metadata:    blobMetadata,
+		},
+	)
+	return errors.WithStack(err)
+}
+
+func (m Manager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	listPrefix := m.buildBlobName("") + "/" + prefix
+	pager := m.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &listPrefix,
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+
+	var entries []remote.Entry
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			entry := remote.Entry{Key: m.relKey(*item.Name), Size: -1, Metadata: toMetadata(item.Metadata)}
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					entry.Size = *item.Properties.ContentLength
+				}
+				if item.Properties.LastModified != nil {
+					entry.Modified = *item.Properties.LastModified
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m Manager) Delete(ctx context.Context, key string) error {
+	_, err := m.client.NewBlobClient(m.buildBlobName(key)).Delete(ctx, &blob.DeleteOptions{
+		DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
+	})
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+func (m Manager) buildBlobName(key string) string {
+	return path.Join(version, m.keyPrefix, key)
+}
+
+func (m Manager) relKey(name string) string {
+	return strings.TrimPrefix(name, m.buildBlobName("")+"/")
+}
+
+var (
+	_ remote.Manager = Manager{}
+	_ remote.Lister  = Manager{}
+	_ remote.Deleter = Manager{}
+)
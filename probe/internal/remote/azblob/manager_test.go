@@ -0,0 +1,217 @@
+package azblobmanager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/caarlos0/env/v9"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Config struct {
+	Host        string `env:"AZURITE_HOST" envDefault:"localhost"`
+	Port        int    `env:"AZURITE_PORT" envDefault:"10000"`
+	AccountName string `env:"AZURITE_ACCOUNT_NAME" envDefault:"devstoreaccount1"`
+	// well-known key of Azurite
+	AccountKey string `env:"AZURITE_ACCOUNT_KEY" envDefault:"Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="` // nolint:lll
+}
+
+func newConfig() (cfg Config, err error) {
+	err = errors.WithStack(env.Parse(&cfg))
+	return
+}
+
+func newManager(ctx context.Context, containerName, keyPrefix string) (Manager, error) {
+	cfg, err := newConfig()
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+
+	connectionString := fmt.Sprintf(
+		"DefaultEndpointsProtocol=http;AccountName=%s;AccountKey=%s;BlobEndpoint=http://%s/%s;",
+		cfg.AccountName,
+		cfg.AccountKey,
+		net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		cfg.AccountName,
+	)
+	manager, err := NewFromConnectionString(connectionString, containerName, keyPrefix)
+	if err != nil {
+		return Manager{}, err
+	}
+
+	_, err = manager.client.Create(ctx, nil)
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+
+	return manager, nil
+}
+
+func TestManager_LoadTo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		predefinedKeys []string
+		keyPrefix      string
+		primaryKey     string
+		secondaryKeys  []string
+		found          bool
+		expectedKey    string
+	}{
+		{
+			name:           "empty",
+			predefinedKeys: nil,
+			keyPrefix:      "",
+			primaryKey:     "",
+			secondaryKeys:  nil,
+			found:          false,
+			expectedKey:    "",
+		},
+		{
+			name:           "single exact matched object",
+			predefinedKeys: []string{"v1/key-with-dashes"},
+			keyPrefix:      "",
+			primaryKey:     "key-with-dashes",
+			secondaryKeys:  nil,
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single exact matched object with prefix",
+			predefinedKeys: []string{"v1/prefixed/key-with-dashes"},
+			keyPrefix:      "prefixed",
+			primaryKey:     "key-with-dashes",
+			secondaryKeys:  nil,
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single exact matched from secondary",
+			predefinedKeys: []string{"v1/prefixed/key-with-dashes"},
+			keyPrefix:      "prefixed",
+			primaryKey:     "does-not-exists",
+			secondaryKeys:  []string{"key-with-dashes"},
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single prefixed matched from secondary",
+			predefinedKeys: []string{"v1/prefixed/key-with-dashes-and-extra"},
+			keyPrefix:      "prefixed",
+			primaryKey:     "does-not-exists",
+			secondaryKeys:  []string{"key-with-dashes"},
+			found:          true,
+			expectedKey:    "key-with-dashes-and-extra",
+		},
+		{
+			name: "multiple matches",
+			predefinedKeys: []string{
+				"v1/prefixed/key-with-dashes-oldest",
+				"v1/prefixed/key-with-dashes-0",
+				"v1/prefixed/key-with-dashes-1",
+				"v1/prefixed/key-with-dashes-newest",
+			},
+			keyPrefix:     "prefixed",
+			primaryKey:    "does-not-exists",
+			secondaryKeys: []string{"key-with-dashes"},
+			found:         true,
+			expectedKey:   "key-with-dashes-newest",
+		},
+		{
+			name: "multiple matches - prefer exact match",
+			predefinedKeys: []string{
+				"v1/prefixed/key-with-dashes-oldest",
+				"v1/prefixed/key-with-dashes-0",
+				"v1/prefixed/key-with-dashes",
+				"v1/prefixed/key-with-dashes-1",
+				"v1/prefixed/key-with-dashes-newest",
+			},
+			keyPrefix:     "prefixed",
+			primaryKey:    "does-not-exists",
+			secondaryKeys: []string{"key-with-dashes"},
+			found:         true,
+			expectedKey:   "key-with-dashes",
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			containerName := strconv.Itoa(rand.Int()) // nolint:gosec
+			manager, err := newManager(ctx, containerName, tc.keyPrefix)
+			require.NoError(t, err)
+
+			for i, key := range tc.predefinedKeys {
+				if i != 0 {
+					// last modified time of Azure Blob has a resolution of a second
+					time.Sleep(1100 * time.Millisecond)
+				}
+				_, err = manager.client.NewBlockBlobClient(key).UploadStream(ctx, strings.NewReader("data"), nil)
+				require.NoError(t, errors.WithStack(err))
+			}
+
+			candidates, err := manager.Load(ctx, tc.primaryKey, tc.secondaryKeys)
+			assert.NoError(t, err)
+			require.Equal(t, tc.found, len(candidates) > 0)
+			if tc.found {
+				assert.Equal(t, tc.expectedKey, candidates[0].Key)
+			}
+		})
+	}
+}
+
+func TestManager_SaveAndLoad(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	containerName := strconv.Itoa(rand.Int()) // nolint:gosec
+	manager, err := newManager(ctx, containerName, "prefixed")
+	require.NoError(t, err)
+
+	metadata := remote.Metadata{"codec": "zstd"}
+	err = manager.Save(ctx, "key", strings.NewReader("data"), 0, metadata)
+	require.NoError(t, err)
+
+	candidates, err := manager.Load(ctx, "key", nil)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	cache, err := candidates[0].Open(ctx)
+	require.NoError(t, err)
+	defer cache.Data.Close()
+
+	loaded, err := io.ReadAll(cache.Data)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(loaded))
+	assert.Equal(t, "key", cache.Key)
+	assert.Equal(t, metadata, cache.Metadata)
+}
+
+func TestManager_Conformance(t *testing.T) {
+	t.Parallel()
+
+	remotetest.TestManager(t, func(t *testing.T) remote.Manager {
+		t.Helper()
+
+		containerName := strconv.Itoa(rand.Int()) // nolint:gosec
+		manager, err := newManager(context.Background(), containerName, "")
+		require.NoError(t, err)
+		return manager
+	}, remotetest.Options{SaveInterval: 1100 * time.Millisecond})
+}
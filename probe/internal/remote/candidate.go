@@ -0,0 +1,23 @@
+package remote
+
+import (
+	"context"
+	"time"
+)
+
+// Candidate is a cache matched by Manager.Load. Its data is not downloaded until Open.
+type Candidate struct {
+	Key string
+	// Modified is when the cache is saved, which prefers newer caches among ones that match the same key.
+	Modified time.Time
+	open     func(ctx context.Context) (LoadedCache, error)
+}
+
+func NewCandidate(key string, modified time.Time, open func(ctx context.Context) (LoadedCache, error)) Candidate {
+	return Candidate{key, modified, open}
+}
+
+// Open starts downloading the cache. Data of the returned cache must be closed by caller.
+func (c Candidate) Open(ctx context.Context) (LoadedCache, error) {
+	return c.open(ctx)
+}
@@ -3,17 +3,26 @@ package remote
 import (
 	"context"
 	"io"
-
-	"github.com/samber/mo"
 )
 
+// Metadata is a set of small attributes (e.g. compression codec) stored alongside of the cache.
+// Keys consist of lowercase letters, digits and underscores, and values are printable ASCII,
+// so that every storage can keep them in its native object metadata.
+type Metadata map[string]string
+
 type LoadedCache struct {
-	Key   string
-	Data  io.ReadCloser
-	Extra map[string]any
+	Key  string
+	Data io.ReadCloser
+	// Metadata is the one given to Manager.Save. It is empty for caches saved by old versions.
+	Metadata Metadata
+	Extra    map[string]any
 }
 
 type Manager interface {
-	Load(ctx context.Context, primaryKey string, secondaryKeys []string) (mo.Option[LoadedCache], error)
-	Save(ctx context.Context, cacheKey string, data []byte) error
+	// Load lists caches that match keys in the order of Resolve, without downloading them.
+	Load(ctx context.Context, primaryKey string, secondaryKeys []string) ([]Candidate, error)
+	// Save streams data to the remote under cacheKey.
+	// sizeHint is a rough estimation of the size of data in bytes, or non-positive if unknown.
+	// It is only used for tuning (e.g. part size of uploads) and must not be trusted as an exact length.
+	Save(ctx context.Context, cacheKey string, data io.Reader, sizeHint int64, metadata Metadata) error
 }
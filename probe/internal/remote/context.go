@@ -0,0 +1,26 @@
+package remote
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader stops reading r once ctx is done,
+// so that managers which copy data by themselves abort Save on cancellation.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return contextReader{ctx, r}
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,10 @@
+package remote
+
+import "context"
+
+// Deleter is implemented by Manager that can remove stored caches.
+type Deleter interface {
+	// Delete removes the cache of key, which is a key of Entry returned by Lister.List.
+	// Deleting a key that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,91 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// envelopeMagic starts the header of envelope.
+// It never collides with the magic numbers of supported compression formats nor with tar archives.
+var envelopeMagic = []byte("BKSTATE\x00")
+
+const (
+	envelopeVersion       = 1
+	maxEnvelopeHeaderSize = 1 << 20
+)
+
+// WrapEnvelope prepends metadata to data,
+// for storages that can not keep metadata alongside of objects (e.g. Github Actions cache).
+func WrapEnvelope(data io.Reader, metadata Metadata) (io.Reader, error) {
+	header, err := EnvelopeHeader(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(header), data), nil
+}
+
+// EnvelopeHeader returns the part of envelope that precedes data.
+//
+// The envelope consists of envelopeMagic, a version byte,
+// big-endian uint32 length of the JSON encoded metadata, the metadata and then data as is.
+func EnvelopeHeader(metadata Metadata) ([]byte, error) {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	header := bytes.NewBuffer(make([]byte, 0, len(envelopeMagic)+1+4+len(encoded)))
+	header.Write(envelopeMagic)
+	header.WriteByte(envelopeVersion)
+	_ = binary.Write(header, binary.BigEndian, uint32(len(encoded)))
+	header.Write(encoded)
+	return header.Bytes(), nil
+}
+
+// UnwrapEnvelope reads metadata from data that is wrapped by WrapEnvelope.
+// Data without envelope, which is saved by old versions, is returned as is with empty metadata.
+func UnwrapEnvelope(data io.Reader) (Metadata, io.Reader, error) {
+	reader := bufio.NewReader(data)
+	magic, err := reader.Peek(len(envelopeMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, errors.WithStack(err)
+	}
+	if !bytes.Equal(magic, envelopeMagic) {
+		return Metadata{}, reader, nil
+	}
+	if _, err = reader.Discard(len(envelopeMagic)); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	version, err := reader.ReadByte()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if version != envelopeVersion {
+		return nil, nil, errors.Errorf("unsupported envelope version: %d", version)
+	}
+
+	var size uint32
+	if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if size > maxEnvelopeHeaderSize {
+		return nil, nil, errors.Errorf("envelope header is too large: %d bytes", size)
+	}
+
+	encoded := make([]byte, size)
+	if _, err = io.ReadFull(reader, encoded); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	metadata := make(Metadata)
+	if err = json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return metadata, reader, nil
+}
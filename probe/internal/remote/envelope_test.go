@@ -0,0 +1,69 @@
+package remote
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		metadata Metadata
+		data     []byte
+	}{
+		{
+			name:     "with metadata",
+			metadata: Metadata{"codec": "zstd", "window_size": "27"},
+			data:     []byte("data"),
+		},
+		{
+			name:     "empty metadata",
+			metadata: Metadata{},
+			data:     []byte("data"),
+		},
+		{
+			name:     "empty data",
+			metadata: Metadata{"codec": "none"},
+			data:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			wrapped, err := WrapEnvelope(bytes.NewReader(tt.data), tt.metadata)
+			require.NoError(t, err)
+
+			metadata, data, err := UnwrapEnvelope(wrapped)
+			require.NoError(t, err)
+			assert.Equal(t, tt.metadata, metadata)
+
+			unwrapped, err := io.ReadAll(data)
+			require.NoError(t, err)
+			assert.Equal(t, string(tt.data), string(unwrapped))
+		})
+	}
+}
+
+func TestUnwrapEnvelope_Legacy(t *testing.T) {
+	t.Parallel()
+
+	for _, legacy := range []string{"", "BK", "\x28\xb5\x2f\xfd-zstd-frame"} {
+		metadata, data, err := UnwrapEnvelope(strings.NewReader(legacy))
+		require.NoError(t, err)
+		assert.Empty(t, metadata)
+
+		unwrapped, err := io.ReadAll(data)
+		require.NoError(t, err)
+		assert.Equal(t, legacy, string(unwrapped))
+	}
+}
@@ -0,0 +1,191 @@
+package gcsmanager
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const version = "v1"
+
+type Manager struct {
+	client    *storage.Client
+	bucket    string
+	keyPrefix string
+}
+
+func New(ctx context.Context, bucket, keyPrefix string, opts ...option.ClientOption) (Manager, error) {
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+	return Manager{client, bucket, keyPrefix}, nil
+}
+
+func (m Manager) Load(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]remote.Candidate, error) {
+	objects, err := m.listMatches(ctx, primaryKey, secondaryKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]remote.Candidate, 0, len(objects))
+	for _, attrs := range objects {
+		attrs := attrs
+		candidates = append(candidates, remote.NewCandidate(
+			m.relKey(attrs.Name),
+			attrs.Updated,
+			func(ctx context.Context) (remote.LoadedCache, error) {
+				return m.open(ctx, attrs)
+			},
+		))
+	}
+
+	return remote.Resolve(candidates, primaryKey, secondaryKeys), nil
+}
+
+func (m Manager) open(ctx context.Context, attrs *storage.ObjectAttrs) (remote.LoadedCache, error) {
+	reader, err := m.client.Bucket(m.bucket).Object(attrs.Name).Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return remote.LoadedCache{}, errors.WithStack(err)
+	}
+
+	metadata := toMetadata(attrs)
+	return remote.LoadedCache{
+		Key:      m.relKey(attrs.Name),
+		Data:     reader,
+		Metadata: metadata,
+		Extra:    metadata.Extra(),
+	}, nil
+}
+
+func toMetadata(attrs *storage.ObjectAttrs) remote.Metadata {
+	metadata := make(remote.Metadata, len(attrs.Metadata))
+	for key, value := range attrs.Metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// listMatches lists every object whose name starts with one of keys.
+func (m Manager) listMatches(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]*storage.ObjectAttrs, error) {
+	keys := make([]string, 0, 1+len(secondaryKeys))
+	keys = append(keys, m.buildObjectName(primaryKey))
+	for _, key := range secondaryKeys {
+		keys = append(keys, m.buildObjectName(key))
+	}
+
+	errGrp, grpCtx := errgroup.WithContext(ctx)
+	listed := make([][]*storage.ObjectAttrs, len(keys))
+	for i, key := range keys {
+		i, key := i, key
+		errGrp.Go(func() error {
+			it := m.client.Bucket(m.bucket).Objects(grpCtx, &storage.Query{Prefix: key})
+			for {
+				attrs, err := it.Next()
+				if errors.Is(err, iterator.Done) {
+					return nil
+				}
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				listed[i] = append(listed[i], attrs)
+			}
+		})
+	}
+	if err := errGrp.Wait(); err != nil {
+		return nil, err
+	}
+
+	// an object is listed multiple times if keys overlap
+	var objects []*storage.ObjectAttrs
+	for _, attrsList := range listed {
+		for _, attrs := range attrsList {
+			if !slices.ContainsFunc(objects, func(o *storage.ObjectAttrs) bool { return o.Name == attrs.Name }) {
+				objects = append(objects, attrs)
+			}
+		}
+	}
+	return objects, nil
+}
+
+func (m Manager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	_ int64,
+	metadata remote.Metadata,
+) error {
+	// cancelling context is the only way to abort upload without committing partially written object.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer := m.client.Bucket(m.bucket).Object(m.buildObjectName(cacheKey)).NewWriter(ctx)
+	writer.Metadata = metadata
+	if _, err := io.Copy(writer, data); err != nil {
+		cancel()
+		_ = writer.Close()
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(writer.Close())
+}
+
+func (m Manager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	var entries []remote.Entry
+	it := m.client.Bucket(m.bucket).Objects(ctx, &storage.Query{Prefix: m.buildObjectName("") + "/" + prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		entries = append(entries, remote.Entry{
+			Key:      m.relKey(attrs.Name),
+			Size:     attrs.Size,
+			Modified: attrs.Updated,
+			Metadata: toMetadata(attrs),
+		})
+	}
+}
+
+func (m Manager) Delete(ctx context.Context, key string) error {
+	err := m.client.Bucket(m.bucket).Object(m.buildObjectName(key)).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+func (m Manager) buildObjectName(key string) string {
+	return path.Join(version, m.keyPrefix, key)
+}
+
+func (m Manager) relKey(name string) string {
+	return strings.TrimPrefix(name, m.buildObjectName("")+"/")
+}
+
+var (
+	_ remote.Manager = Manager{}
+	_ remote.Lister  = Manager{}
+	_ remote.Deleter = Manager{}
+)
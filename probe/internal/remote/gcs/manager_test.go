@@ -0,0 +1,218 @@
+package gcsmanager
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"cloud.google.com/go/storage"
+	"github.com/caarlos0/env/v9"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+)
+
+type Config struct {
+	Host      string `env:"GCS_HOST" envDefault:"localhost"`
+	Port      int    `env:"GCS_PORT"`
+	ProjectID string `env:"GCS_PROJECT_ID" envDefault:"test"`
+}
+
+func newConfig() (cfg Config, err error) {
+	err = errors.WithStack(env.Parse(&cfg))
+	return
+}
+
+func newManager(ctx context.Context, bucket, keyPrefix string) (Manager, error) {
+	cfg, err := newConfig()
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+
+	manager, err := New(
+		ctx,
+		bucket,
+		keyPrefix,
+		option.WithEndpoint("http://"+net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))+"/storage/v1/"),
+		option.WithoutAuthentication(),
+		// fake-gcs-server does not handle escaped object name on XML API
+		storage.WithJSONReads(),
+	)
+	if err != nil {
+		return Manager{}, err
+	}
+
+	err = manager.client.Bucket(bucket).Create(ctx, cfg.ProjectID, nil)
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+
+	return manager, nil
+}
+
+func TestManager_LoadTo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		predefinedKeys []string
+		keyPrefix      string
+		primaryKey     string
+		secondaryKeys  []string
+		found          bool
+		expectedKey    string
+	}{
+		{
+			name:           "empty",
+			predefinedKeys: nil,
+			keyPrefix:      "",
+			primaryKey:     "",
+			secondaryKeys:  nil,
+			found:          false,
+			expectedKey:    "",
+		},
+		{
+			name:           "single exact matched object",
+			predefinedKeys: []string{"v1/key-with-dashes"},
+			keyPrefix:      "",
+			primaryKey:     "key-with-dashes",
+			secondaryKeys:  nil,
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single exact matched object with prefix",
+			predefinedKeys: []string{"v1/prefixed/key-with-dashes"},
+			keyPrefix:      "prefixed",
+			primaryKey:     "key-with-dashes",
+			secondaryKeys:  nil,
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single exact matched from secondary",
+			predefinedKeys: []string{"v1/prefixed/key-with-dashes"},
+			keyPrefix:      "prefixed",
+			primaryKey:     "does-not-exists",
+			secondaryKeys:  []string{"key-with-dashes"},
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single prefixed matched from secondary",
+			predefinedKeys: []string{"v1/prefixed/key-with-dashes-and-extra"},
+			keyPrefix:      "prefixed",
+			primaryKey:     "does-not-exists",
+			secondaryKeys:  []string{"key-with-dashes"},
+			found:          true,
+			expectedKey:    "key-with-dashes-and-extra",
+		},
+		{
+			name: "multiple matches",
+			predefinedKeys: []string{
+				"v1/prefixed/key-with-dashes-oldest",
+				"v1/prefixed/key-with-dashes-0",
+				"v1/prefixed/key-with-dashes-1",
+				"v1/prefixed/key-with-dashes-newest",
+			},
+			keyPrefix:     "prefixed",
+			primaryKey:    "does-not-exists",
+			secondaryKeys: []string{"key-with-dashes"},
+			found:         true,
+			expectedKey:   "key-with-dashes-newest",
+		},
+		{
+			name: "multiple matches - prefer exact match",
+			predefinedKeys: []string{
+				"v1/prefixed/key-with-dashes-oldest",
+				"v1/prefixed/key-with-dashes-0",
+				"v1/prefixed/key-with-dashes",
+				"v1/prefixed/key-with-dashes-1",
+				"v1/prefixed/key-with-dashes-newest",
+			},
+			keyPrefix:     "prefixed",
+			primaryKey:    "does-not-exists",
+			secondaryKeys: []string{"key-with-dashes"},
+			found:         true,
+			expectedKey:   "key-with-dashes",
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			bucket := strconv.Itoa(rand.Int()) // nolint:gosec
+			manager, err := newManager(ctx, bucket, tc.keyPrefix)
+			require.NoError(t, err)
+
+			for i, key := range tc.predefinedKeys {
+				if i != 0 {
+					time.Sleep(100 * time.Millisecond)
+				}
+				writer := manager.client.Bucket(bucket).Object(key).NewWriter(ctx)
+				_, err = writer.Write([]byte("data"))
+				require.NoError(t, errors.WithStack(err))
+				require.NoError(t, errors.WithStack(writer.Close()))
+			}
+
+			candidates, err := manager.Load(ctx, tc.primaryKey, tc.secondaryKeys)
+			assert.NoError(t, err)
+			require.Equal(t, tc.found, len(candidates) > 0)
+			if tc.found {
+				assert.Equal(t, tc.expectedKey, candidates[0].Key)
+			}
+		})
+	}
+}
+
+func TestManager_SaveAndLoad(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	bucket := strconv.Itoa(rand.Int()) // nolint:gosec
+	manager, err := newManager(ctx, bucket, "prefixed")
+	require.NoError(t, err)
+
+	metadata := remote.Metadata{"codec": "zstd"}
+	err = manager.Save(ctx, "key", strings.NewReader("data"), 0, metadata)
+	require.NoError(t, err)
+
+	candidates, err := manager.Load(ctx, "key", nil)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	cache, err := candidates[0].Open(ctx)
+	require.NoError(t, err)
+	defer cache.Data.Close()
+
+	loaded, err := io.ReadAll(cache.Data)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(loaded))
+	assert.Equal(t, "key", cache.Key)
+	assert.Equal(t, metadata, cache.Metadata)
+}
+
+func TestManager_Conformance(t *testing.T) {
+	t.Parallel()
+
+	remotetest.TestManager(t, func(t *testing.T) remote.Manager {
+		t.Helper()
+
+		bucket := strconv.Itoa(rand.Int()) // nolint:gosec
+		manager, err := newManager(context.Background(), bucket, "")
+		require.NoError(t, err)
+		return manager
+	}, remotetest.Options{SaveInterval: 100 * time.Millisecond})
+}
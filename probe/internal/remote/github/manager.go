@@ -3,16 +3,19 @@ package githubmanager
 import (
 	"context"
 	"io"
+	"os"
+	"time"
 
 	"github.com/isac322/buildkit-state/probe/internal/remote"
 
 	"github.com/pkg/errors"
-	"github.com/samber/mo"
 	actionscache "github.com/tonistiigi/go-actions-cache"
+	"golang.org/x/exp/slices"
 )
 
 type Manager struct {
-	gha *actionscache.Cache
+	gha  *actionscache.Cache
+	rest restClient
 }
 
 func New() (Manager, error) {
@@ -20,37 +23,121 @@ func New() (Manager, error) {
 	if err != nil {
 		return Manager{}, errors.WithStack(err)
 	}
-	return Manager{gha}, nil
+	return Manager{gha, newRESTClient()}, nil
 }
 
+// Load queries each key separately, because Github Actions Cache returns only one cache for a query
+// even if multiple keys are given. It resolves a key into its exact match or the newest prefix match,
+// so a query per key yields candidates in the order of remote.Resolve, but at most one per key.
 func (m Manager) Load(
 	ctx context.Context,
 	primaryKey string,
 	secondaryKeys []string,
-) (mo.Option[remote.LoadedCache], error) {
+) ([]remote.Candidate, error) {
 	keys := make([]string, 0, 1+len(secondaryKeys))
 	keys = append(keys, primaryKey)
 	keys = append(keys, secondaryKeys...)
-	cache, err := m.gha.Load(ctx, keys...)
-	if err != nil {
-		return mo.None[remote.LoadedCache](), errors.WithStack(err)
+
+	var candidates []remote.Candidate
+	for i, key := range keys {
+		cache, err := m.gha.Load(ctx, key)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if cache == nil {
+			continue
+		}
+		// primary key is matched only exactly
+		if i == 0 && cache.Key != primaryKey {
+			continue
+		}
+		// the same cache is matched by multiple keys
+		if slices.ContainsFunc(candidates, func(c remote.Candidate) bool { return c.Key == cache.Key }) {
+			continue
+		}
+
+		candidates = append(candidates, remote.NewCandidate(
+			cache.Key,
+			time.Time{},
+			func(ctx context.Context) (remote.LoadedCache, error) {
+				return open(ctx, cache)
+			},
+		))
 	}
-	if cache == nil {
-		return mo.None[remote.LoadedCache](), nil
+
+	return candidates, nil
+}
+
+func open(ctx context.Context, cache *actionscache.Entry) (remote.LoadedCache, error) {
+	body := &wrappedBody{cache.Download(ctx), 0}
+	metadata, data, err := remote.UnwrapEnvelope(body)
+	if err != nil {
+		_ = body.Close()
+		return remote.LoadedCache{}, err
 	}
 
-	return mo.Some(remote.LoadedCache{
-		Key:   cache.Key,
-		Data:  &wrappedBody{cache.Download(ctx), 0},
-		Extra: nil,
-	}), nil
+	return remote.LoadedCache{
+		Key:      cache.Key,
+		Data:     readCloser{data, body},
+		Metadata: metadata,
+		Extra:    metadata.Extra(),
+	}, nil
 }
 
-func (m Manager) Save(ctx context.Context, cacheKey string, data []byte) error {
-	return errors.WithStack(m.gha.Save(ctx, cacheKey, actionscache.NewBlob(data)))
+func (m Manager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	sizeHint int64,
+	metadata remote.Metadata,
+) error {
+	return m.SaveWithTrailingMetadata(ctx, cacheKey, data, sizeHint, remote.StaticMetadata(metadata))
+}
+
+// SaveWithTrailingMetadata spools data into a temporary file before uploading,
+// because Github Actions Cache requires the total size and random access to upload chunks concurrently.
+// It keeps memory usage constant regardless of the size of data.
+// Metadata is stored in the envelope, because Github Actions Cache has nowhere else to keep it.
+// The header of envelope is prepended to the spooled data on upload, so data is spooled only once.
+func (m Manager) SaveWithTrailingMetadata(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	_ int64,
+	metadata func() (remote.Metadata, error),
+) error {
+	fp, err := os.CreateTemp("", "buildkit-state-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+	}()
+
+	size, err := io.Copy(fp, data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	resolved, err := metadata()
+	if err != nil {
+		return err
+	}
+	header, err := remote.EnvelopeHeader(resolved)
+	if err != nil {
+		return err
+	}
+
+	return errors.WithStack(m.gha.Save(ctx, cacheKey, envelopeBlob{header, fileBlob{fp, size}}))
 }
 
-var _ remote.Manager = Manager{}
+var (
+	_ remote.Manager               = Manager{}
+	_ remote.Lister                = Manager{}
+	_ remote.Deleter               = Manager{}
+	_ remote.TrailingMetadataSaver = Manager{}
+)
 
 type wrappedBody struct {
 	actionscache.ReaderAtCloser
@@ -65,3 +152,54 @@ func (r *wrappedBody) Read(b []byte) (int, error) {
 	}
 	return n, err
 }
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type fileBlob struct {
+	*os.File
+	size int64
+}
+
+func (b fileBlob) Size() int64 {
+	return b.size
+}
+
+// Close is no-op because the file is owned by Manager.Save.
+func (b fileBlob) Close() error {
+	return nil
+}
+
+// envelopeBlob is header of envelope followed by data.
+type envelopeBlob struct {
+	header []byte
+	data   fileBlob
+}
+
+func (b envelopeBlob) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	if headerSize := int64(len(b.header)); off < headerSize {
+		n = copy(p, b.header[off:])
+		if n == len(p) {
+			return n, nil
+		}
+		off = headerSize
+	}
+	m, err := b.data.ReadAt(p[n:], off-int64(len(b.header)))
+	return n + m, err
+}
+
+func (b envelopeBlob) Size() int64 {
+	return int64(len(b.header)) + b.data.Size()
+}
+
+func (b envelopeBlob) Close() error {
+	return b.data.Close()
+}
+
+var (
+	_ actionscache.Blob = fileBlob{}
+	_ actionscache.Blob = envelopeBlob{}
+)
@@ -0,0 +1,56 @@
+package githubmanager
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeBlob(t *testing.T) {
+	t.Parallel()
+
+	data := strings.Repeat("compressed buildkit state", 100)
+	metadata := remote.Metadata{"codec": "zstd"}
+	fp, err := os.Create(filepath.Join(t.TempDir(), "data"))
+	require.NoError(t, err)
+	defer fp.Close()
+	_, err = fp.WriteString(data)
+	require.NoError(t, err)
+
+	header, err := remote.EnvelopeHeader(metadata)
+	require.NoError(t, err)
+	blob := envelopeBlob{header, fileBlob{fp, int64(len(data))}}
+
+	wrapped, err := remote.WrapEnvelope(strings.NewReader(data), metadata)
+	require.NoError(t, err)
+	expected, err := io.ReadAll(wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(expected)), blob.Size())
+
+	actual, err := io.ReadAll(&wrappedBody{ReaderAtCloser: blob})
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// chunks that are within the header, across the boundary and within data
+	for _, size := range []int{1, 7, len(header), len(header) + 1, 1000} {
+		chunk := make([]byte, size)
+		for off := 0; off < len(expected); off += size {
+			n, err := blob.ReadAt(chunk, int64(off))
+			if err != nil {
+				require.ErrorIs(t, err, io.EOF)
+			}
+			expectedChunk := expected[off:]
+			if len(expectedChunk) > size {
+				expectedChunk = expectedChunk[:size]
+			}
+			assert.Equal(t, expectedChunk, chunk[:n], "offset %d of chunk %d", off, size)
+		}
+	}
+}
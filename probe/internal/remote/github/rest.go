@@ -0,0 +1,184 @@
+package githubmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAPIURL = "https://api.github.com"
+	listPageSize  = 100
+)
+
+// restClient calls REST API of GitHub, because the cache service that caches are saved into can not enumerate them.
+type restClient struct {
+	client     *http.Client
+	url        string
+	repository string
+	token      string
+}
+
+// newRESTClient reads the repository and its API from environment variables that the runner sets.
+// GITHUB_TOKEN has to be passed explicitly (e.g. `env: {GITHUB_TOKEN: ${{ github.token }}}`).
+func newRESTClient() restClient {
+	apiURL := os.Getenv("GITHUB_API_URL")
+	if apiURL == "" {
+		apiURL = defaultAPIURL
+	}
+	return restClient{
+		client:     http.DefaultClient,
+		url:        apiURL,
+		repository: os.Getenv("GITHUB_REPOSITORY"),
+		token:      os.Getenv("GITHUB_TOKEN"),
+	}
+}
+
+type cacheItem struct {
+	ID          int64     `json:"id"`
+	Ref         string    `json:"ref"`
+	Key         string    `json:"key"`
+	CreatedAt   time.Time `json:"created_at"`
+	SizeInBytes int64     `json:"size_in_bytes"`
+}
+
+type cacheList struct {
+	TotalCount    int         `json:"total_count"`
+	ActionsCaches []cacheItem `json:"actions_caches"`
+}
+
+func (c restClient) listCaches(ctx context.Context, prefix string) ([]cacheItem, error) {
+	if c.repository == "" || c.token == "" {
+		return nil, errors.New("GITHUB_REPOSITORY and GITHUB_TOKEN are required to list caches")
+	}
+
+	var items []cacheItem
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("per_page", strconv.Itoa(listPageSize))
+		query.Set("page", strconv.Itoa(page))
+		if prefix != "" {
+			query.Set("key", prefix)
+		}
+
+		var list cacheList
+		err := c.do(ctx, http.MethodGet, "/repos/"+c.repository+"/actions/caches?"+query.Encode(), &list)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list.ActionsCaches...)
+		if len(list.ActionsCaches) == 0 || len(items) >= list.TotalCount {
+			return items, nil
+		}
+	}
+}
+
+// deleteCaches deletes caches of key on every ref. Missing key is not an error.
+func (c restClient) deleteCaches(ctx context.Context, key string) error {
+	if c.repository == "" || c.token == "" {
+		return errors.New("GITHUB_REPOSITORY and GITHUB_TOKEN are required to delete caches")
+	}
+
+	query := url.Values{}
+	query.Set("key", key)
+	err := c.do(ctx, http.MethodDelete, "/repos/"+c.repository+"/actions/caches?"+query.Encode(), nil)
+	var errStatus statusError
+	if errors.As(err, &errStatus) && errStatus.code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+type statusError struct {
+	method string
+	path   string
+	status string
+	code   int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.path, e.status)
+}
+
+func (c restClient) do(ctx context.Context, method, path string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.WithStack(statusError{method, path, resp.Status, resp.StatusCode})
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.WithStack(json.NewDecoder(resp.Body).Decode(result))
+}
+
+// List reads metadata from the envelope of each cache, which costs a ranged download of its head.
+// Caches that are not accessible from the current workflow run (e.g. of other branches) do not have metadata.
+func (m Manager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	items, err := m.rest.listCaches(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]remote.Entry, 0, len(items))
+	for _, item := range items {
+		metadata, err := m.readMetadata(ctx, item.Key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, remote.Entry{
+			Key:      item.Key,
+			Size:     item.SizeInBytes,
+			Modified: item.CreatedAt,
+			Metadata: metadata,
+		})
+	}
+	return entries, nil
+}
+
+// Delete uses REST API of GitHub, because the cache service does not delete caches.
+func (m Manager) Delete(ctx context.Context, key string) error {
+	return m.rest.deleteCaches(ctx, key)
+}
+
+func (m Manager) readMetadata(ctx context.Context, key string) (remote.Metadata, error) {
+	if m.gha == nil {
+		return remote.Metadata{}, nil
+	}
+	cache, err := m.gha.Load(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if cache == nil || cache.Key != key {
+		return remote.Metadata{}, nil
+	}
+
+	body := &wrappedBody{cache.Download(ctx), 0}
+	defer body.Close()
+	metadata, _, err := remote.UnwrapEnvelope(body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read metadata of %s", key)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,110 @@
+package githubmanager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_List(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	pages := [][]cacheItem{
+		{
+			{ID: 1, Key: "linux-a", CreatedAt: created, SizeInBytes: 10},
+			{ID: 2, Key: "linux-b", CreatedAt: created.Add(time.Hour), SizeInBytes: 20},
+		},
+		{
+			{ID: 3, Key: "linux-c", CreatedAt: created.Add(2 * time.Hour), SizeInBytes: 30},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/actions/caches", r.URL.Path)
+		assert.Equal(t, "linux-", r.URL.Query().Get("key"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		require.NoError(t, err)
+		list := cacheList{TotalCount: 3}
+		if page <= len(pages) {
+			list.ActionsCaches = pages[page-1]
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(list))
+	}))
+	t.Cleanup(server.Close)
+
+	manager := Manager{rest: restClient{
+		client:     server.Client(),
+		url:        server.URL,
+		repository: "owner/repo",
+		token:      "token",
+	}}
+	entries, err := manager.List(context.Background(), "linux-")
+	require.NoError(t, err)
+	assert.Equal(t, []remote.Entry{
+		{Key: "linux-a", Size: 10, Modified: created, Metadata: remote.Metadata{}},
+		{Key: "linux-b", Size: 20, Modified: created.Add(time.Hour), Metadata: remote.Metadata{}},
+		{Key: "linux-c", Size: 30, Modified: created.Add(2 * time.Hour), Metadata: remote.Metadata{}},
+	}, entries)
+}
+
+func TestManager_List_WithoutToken(t *testing.T) {
+	t.Parallel()
+
+	manager := Manager{rest: restClient{repository: "owner/repo"}}
+	_, err := manager.List(context.Background(), "")
+	assert.Error(t, err)
+}
+
+func TestManager_Delete(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		status        int
+		expectedError bool
+	}{
+		{name: "deleted", status: http.StatusOK},
+		{name: "missing key", status: http.StatusNotFound},
+		{name: "forbidden", status: http.StatusForbidden, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodDelete, r.Method)
+				assert.Equal(t, "/repos/owner/repo/actions/caches", r.URL.Path)
+				assert.Equal(t, "linux-a", r.URL.Query().Get("key"))
+				w.WriteHeader(tt.status)
+			}))
+			t.Cleanup(server.Close)
+
+			manager := Manager{rest: restClient{
+				client:     server.Client(),
+				url:        server.URL,
+				repository: "owner/repo",
+				token:      "token",
+			}}
+			err := manager.Delete(context.Background(), "linux-a")
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package remote
+
+import (
+	"context"
+	"time"
+)
+
+// Entry describes a stored cache without downloading its data.
+type Entry struct {
+	Key string
+	// Size is the size of the stored data in bytes, or negative if unknown.
+	Size int64
+	// Modified is when the cache is saved.
+	Modified time.Time
+	// Metadata is the one given to Manager.Save.
+	// It is empty for caches saved by old versions or if the storage does not expose it without downloading.
+	Metadata Metadata
+}
+
+// Lister is implemented by Manager that can enumerate stored caches.
+type Lister interface {
+	// List returns every cache whose key starts with prefix, in no particular order.
+	// Keys are the same with keys of candidates returned by Manager.Load.
+	List(ctx context.Context, prefix string) ([]Entry, error)
+}
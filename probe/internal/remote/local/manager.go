@@ -1,21 +1,25 @@
 package localmanager
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/isac322/buildkit-state/probe/internal/remote"
 
 	"github.com/pkg/errors"
-	"github.com/samber/mo"
 	"golang.org/x/exp/slices"
 )
 
-const version = "v1"
+const (
+	version        = "v1"
+	metadataSuffix = ".metadata.json"
+)
 
 type Manager struct {
 	dest string
@@ -25,22 +29,16 @@ func New(destinationPath string) Manager {
 	return Manager{destinationPath}
 }
 
-type entry struct {
-	FullPath string
-	ModTime  time.Time
-}
-
 func (m Manager) Load(
 	_ context.Context,
 	primaryKey string,
 	secondaryKeys []string,
-) (mo.Option[remote.LoadedCache], error) {
+) ([]remote.Candidate, error) {
 	keys := make([]string, 0, 1+len(secondaryKeys))
 	keys = append(keys, primaryKey)
 	keys = append(keys, secondaryKeys...)
 
-	var matched mo.Option[entry]
-
+	var candidates []remote.Candidate
 	err := filepath.WalkDir(
 		filepath.Join(m.dest, version),
 		func(path string, d fs.DirEntry, err error) error {
@@ -51,82 +49,218 @@ func (m Manager) Load(
 				return nil
 			}
 			filename := d.Name()
-
-			// exact match
-			if slices.Contains(keys, filename) {
-				info, err := d.Info()
-				if err != nil {
-					return errors.WithStack(err)
-				}
-
-				matched = mo.Some(entry{FullPath: path, ModTime: info.ModTime()})
-				return filepath.SkipAll
+			if strings.HasPrefix(filename, ".") {
+				// incomplete file that is being written by Save, or sidecar of metadata
+				return nil
 			}
 
-			// prefix match
-			if slices.ContainsFunc(
-				keys,
-				func(s string) bool {
-					return strings.HasPrefix(filename, s)
+			if !slices.ContainsFunc(keys, func(key string) bool { return strings.HasPrefix(filename, key) }) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			candidates = append(candidates, remote.NewCandidate(
+				filename,
+				info.ModTime(),
+				func(context.Context) (remote.LoadedCache, error) {
+					return open(path)
 				},
-			) {
-				info, err := d.Info()
-				if err != nil {
-					return errors.WithStack(err)
-				}
+			))
+			return nil
+		},
+	)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-				if prev, exist := matched.Get(); exist && prev.ModTime.After(info.ModTime()) {
-					return nil
-				}
+	return remote.Resolve(candidates, primaryKey, secondaryKeys), nil
+}
 
-				matched = mo.Some(entry{FullPath: path, ModTime: info.ModTime()})
+func open(fullPath string) (remote.LoadedCache, error) {
+	metadata, err := readMetadata(fullPath)
+	if err != nil {
+		return remote.LoadedCache{}, err
+	}
+	fp, err := os.Open(fullPath)
+	if err != nil {
+		return remote.LoadedCache{}, errors.WithStack(err)
+	}
+
+	return remote.LoadedCache{
+		Key:      filepath.Base(fullPath),
+		Data:     fp,
+		Metadata: metadata,
+		Extra:    metadata.Extra(),
+	}, nil
+}
+
+func (m Manager) List(_ context.Context, prefix string) ([]remote.Entry, error) {
+	var entries []remote.Entry
+	err := filepath.WalkDir(
+		filepath.Join(m.dest, version),
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			filename := d.Name()
+			if d.IsDir() || strings.HasPrefix(filename, ".") || !strings.HasPrefix(filename, prefix) {
 				return nil
 			}
 
+			info, err := d.Info()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			metadata, err := readMetadata(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, remote.Entry{
+				Key:      filename,
+				Size:     info.Size(),
+				Modified: info.ModTime(),
+				Metadata: metadata,
+			})
 			return nil
 		},
 	)
 	if errors.Is(err, os.ErrNotExist) {
-		return mo.None[remote.LoadedCache](), nil
-	}
-	if err != nil {
-		return mo.None[remote.LoadedCache](), err
+		return nil, nil
 	}
+	return entries, err
+}
 
-	fileInfo, found := matched.Get()
-	if !found {
-		return mo.None[remote.LoadedCache](), nil
+// Delete removes the cache before its metadata, so that a cache is never seen without its metadata.
+func (m Manager) Delete(_ context.Context, key string) error {
+	cachePath := filepath.Join(m.dest, version, key)
+	for _, path := range []string{cachePath, metadataPath(cachePath)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.WithStack(err)
+		}
 	}
+	return nil
+}
 
-	fullPath := fileInfo.FullPath
-	fp, err := os.Open(fullPath)
+// metadataPath returns path of the sidecar file that keeps metadata of the cache.
+// It is hidden, so that it is not matched as a cache by Load.
+func metadataPath(cachePath string) string {
+	return filepath.Join(filepath.Dir(cachePath), "."+filepath.Base(cachePath)+metadataSuffix)
+}
+
+func readMetadata(cachePath string) (remote.Metadata, error) {
+	raw, err := os.ReadFile(metadataPath(cachePath))
+	if errors.Is(err, os.ErrNotExist) {
+		// saved by old versions
+		return remote.Metadata{}, nil
+	}
 	if err != nil {
-		return mo.None[remote.LoadedCache](), errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	return mo.Some(remote.LoadedCache{
-		Key:   filepath.Base(fullPath),
-		Data:  fp,
-		Extra: nil,
-	}), err
+	metadata := make(remote.Metadata)
+	if err = json.Unmarshal(raw, &metadata); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return metadata, nil
+}
+
+func (m Manager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	sizeHint int64,
+	metadata remote.Metadata,
+) error {
+	return m.SaveWithTrailingMetadata(ctx, cacheKey, data, sizeHint, remote.StaticMetadata(metadata))
 }
 
-func (m Manager) Save(_ context.Context, cacheKey string, data []byte) error {
-	err := os.MkdirAll(filepath.Join(m.dest, version), os.ModePerm)
+func (m Manager) SaveWithTrailingMetadata(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	_ int64,
+	metadata func() (remote.Metadata, error),
+) error {
+	dir := filepath.Join(m.dest, version)
+	err := os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	fp, err := os.OpenFile(filepath.Join(m.dest, version, cacheKey), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o660)
+	cachePath := filepath.Join(dir, cacheKey)
+	tempPath, err := writeTemp(dir, cacheKey, remote.NewContextReader(ctx, data))
 	if err != nil {
+		return err
+	}
+	resolved, err := metadata()
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	encoded, err := json.Marshal(resolved)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return errors.WithStack(err)
+	}
+	// old metadata is removed before the data is replaced, so that new data is never paired with old metadata.
+	// In between, the old data is read as the one saved by old versions, which is still valid.
+	if err = os.Remove(metadataPath(cachePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = os.Remove(tempPath)
+		return errors.WithStack(err)
+	}
+	if err = os.Rename(tempPath, cachePath); err != nil {
+		_ = os.Remove(tempPath)
 		return errors.WithStack(err)
 	}
 
-	if _, err = fp.Write(data); err != nil {
+	// metadata goes last. If it fails, the data is removed as well, rather than left without checksum.
+	tempPath, err = writeTemp(dir, cacheKey, bytes.NewReader(encoded))
+	if err != nil {
+		_ = os.Remove(cachePath)
+		return err
+	}
+	if err = os.Rename(tempPath, metadataPath(cachePath)); err != nil {
+		_ = os.Remove(tempPath)
+		_ = os.Remove(cachePath)
 		return errors.WithStack(err)
 	}
+	return nil
+}
+
+// writeTemp writes data into a temporary file in dir and returns its path,
+// so that interrupted stream does not leave truncated file behind.
+func writeTemp(dir, cacheKey string, data io.Reader) (_ string, err error) {
+	fp, err := os.CreateTemp(dir, "."+cacheKey+".*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = fp.Close()
+			_ = os.Remove(fp.Name())
+		}
+	}()
 
-	return errors.WithStack(fp.Close())
+	if _, err = io.Copy(fp, data); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err = fp.Chmod(0o660); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err = fp.Close(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return fp.Name(), nil
 }
 
-var _ remote.Manager = Manager{}
+var (
+	_ remote.Manager               = Manager{}
+	_ remote.Lister                = Manager{}
+	_ remote.Deleter               = Manager{}
+	_ remote.TrailingMetadataSaver = Manager{}
+)
@@ -0,0 +1,60 @@
+package localmanager
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_Conformance(t *testing.T) {
+	t.Parallel()
+
+	remotetest.TestManager(t, func(t *testing.T) remote.Manager {
+		t.Helper()
+
+		return New(t.TempDir())
+	}, remotetest.Options{SaveInterval: 10 * time.Millisecond})
+}
+
+func TestManager_FailedSave(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dest := t.TempDir()
+	manager := New(dest)
+	failing := func() io.Reader {
+		return io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	}
+
+	// first save leaves nothing behind
+	require.Error(t, manager.Save(ctx, "new", failing(), 0, remote.Metadata{"codec": "zstd"}))
+	files, err := os.ReadDir(filepath.Join(dest, version))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	// overwrite keeps the old data paired with its metadata
+	require.NoError(t, manager.Save(ctx, "key", strings.NewReader("old"), 0, remote.Metadata{"codec": "gzip"}))
+	require.Error(t, manager.Save(ctx, "key", failing(), 0, remote.Metadata{"codec": "zstd"}))
+
+	candidates, err := manager.Load(ctx, "key", nil)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	loaded, err := candidates[0].Open(ctx)
+	require.NoError(t, err)
+	defer loaded.Data.Close()
+	data, err := io.ReadAll(loaded.Data)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+	assert.Equal(t, remote.Metadata{"codec": "gzip"}, loaded.Metadata)
+}
@@ -0,0 +1,71 @@
+package remote
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/mo"
+)
+
+const (
+	// MetadataManifest is the key of Metadata that stores Manifest as JSON.
+	MetadataManifest = "manifest"
+	// ExtraManifest is the key of LoadedCache.Extra that holds decoded Manifest.
+	ExtraManifest = "manifest"
+)
+
+// Manifest describes the environment that the state is saved from,
+// because state of buildkitd can not be restored into incompatible one.
+type Manifest struct {
+	BuildkitVersion string    `json:"buildkit_version"`
+	Snapshotter     string    `json:"snapshotter"`
+	Platform        string    `json:"platform"`
+	TargetTypes     []string  `json:"target_types"`
+	CreatedAt       time.Time `json:"created_at"`
+	ProbeVersion    string    `json:"probe_version"`
+}
+
+// SetManifest encodes manifest into m.
+func (m Metadata) SetManifest(manifest Manifest) error {
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	m[MetadataManifest] = string(raw)
+	return nil
+}
+
+// Manifest decodes manifest from m. Caches saved by old versions do not have it.
+func (m Metadata) Manifest() (mo.Option[Manifest], error) {
+	raw, found := m[MetadataManifest]
+	if !found {
+		return mo.None[Manifest](), nil
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(raw), &manifest); err != nil {
+		return mo.None[Manifest](), errors.Wrap(err, "invalid manifest")
+	}
+	return mo.Some(manifest), nil
+}
+
+// Extra decodes structured values of m to be exposed through LoadedCache.Extra.
+// Malformed values are omitted rather than failing Load, so that they are handled as caches of old versions.
+func (m Metadata) Extra() map[string]any {
+	extra := make(map[string]any)
+	if manifest, err := m.Manifest(); err == nil {
+		if value, found := manifest.Get(); found {
+			extra[ExtraManifest] = value
+		}
+	}
+	return extra
+}
+
+// Manifest returns Manifest that Manager exposed through Extra.
+func (c LoadedCache) Manifest() mo.Option[Manifest] {
+	if manifest, ok := c.Extra[ExtraManifest].(Manifest); ok {
+		return mo.Some(manifest)
+	}
+	return mo.None[Manifest]()
+}
@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata_Manifest(t *testing.T) {
+	t.Parallel()
+
+	manifest := Manifest{
+		BuildkitVersion: "v0.12.3",
+		Snapshotter:     "overlayfs",
+		Platform:        "linux/amd64",
+		TargetTypes:     []string{"exec.cachemount", "frontend"},
+		CreatedAt:       time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC),
+		ProbeVersion:    "v1.0.0",
+	}
+
+	metadata := Metadata{}
+	require.NoError(t, metadata.SetManifest(manifest))
+	decoded, err := metadata.Manifest()
+	require.NoError(t, err)
+	assert.Equal(t, mo.Some(manifest), decoded)
+	assert.Equal(t, mo.Some(manifest), LoadedCache{Extra: metadata.Extra()}.Manifest())
+
+	decoded, err = Metadata{}.Manifest()
+	require.NoError(t, err)
+	assert.Equal(t, mo.None[Manifest](), decoded)
+
+	malformed := Metadata{MetadataManifest: "{"}
+	_, err = malformed.Manifest()
+	assert.Error(t, err)
+	assert.Equal(t, mo.None[Manifest](), LoadedCache{Extra: malformed.Extra()}.Manifest())
+}
@@ -0,0 +1,363 @@
+package ocimanager
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	orasremote "oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+const (
+	// ArtifactType is used as config media type of the manifest,
+	// so that registries which do not support OCI 1.1 artifacts can store the state.
+	ArtifactType = "application/vnd.buildkit-state.config.v1+json"
+	// LayerMediaType does not tell the compression codec, which is recorded in the annotations instead.
+	LayerMediaType = "application/vnd.buildkit-state.layer.v1.tar"
+
+	AnnotationCacheKey = "io.github.isac322.buildkit-state.cache-key"
+	// AnnotationMetadataPrefix is prepended to each key of remote.Metadata to store it as an annotation of manifest.
+	AnnotationMetadataPrefix = "io.github.isac322.buildkit-state.metadata."
+
+	maxTagLength = 128
+)
+
+type Manager struct {
+	repo *orasremote.Repository
+}
+
+type Options struct {
+	PlainHTTP bool
+	Username  string
+	Password  string
+}
+
+// New creates Manager which stores states in repository (e.g. ghcr.io/owner/repo).
+func New(repository string, opts Options) (Manager, error) {
+	repo, err := orasremote.NewRepository(repository)
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+	repo.PlainHTTP = opts.PlainHTTP
+
+	client := &auth.Client{
+		Client: retry.DefaultClient,
+		Cache:  auth.NewCache(),
+	}
+	if opts.Username != "" || opts.Password != "" {
+		client.Credential = auth.StaticCredential(
+			repo.Reference.Registry,
+			auth.Credential{Username: opts.Username, Password: opts.Password},
+		)
+	}
+	repo.Client = client
+
+	return Manager{repo}, nil
+}
+
+func (m Manager) Load(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]remote.Candidate, error) {
+	tags, err := m.listMatchedTags(ctx, primaryKey, secondaryKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]remote.Candidate, 0, len(tags))
+	for _, tag := range tags {
+		_, manifest, err := m.fetchManifest(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		// registry does not keep time of tagging, so the creation time recorded in manifest is used.
+		created, err := time.Parse(time.RFC3339Nano, manifest.Annotations[ocispec.AnnotationCreated])
+		if err != nil {
+			// not created by this manager
+			continue
+		}
+
+		key := manifest.Annotations[AnnotationCacheKey]
+		if key == "" {
+			key = tag
+		}
+		candidates = append(candidates, remote.NewCandidate(
+			key,
+			created,
+			func(ctx context.Context) (remote.LoadedCache, error) {
+				return m.open(ctx, tag, key, manifest)
+			},
+		))
+	}
+
+	return remote.Resolve(candidates, primaryKey, secondaryKeys), nil
+}
+
+func (m Manager) open(
+	ctx context.Context,
+	tag string,
+	key string,
+	manifest ocispec.Manifest,
+) (remote.LoadedCache, error) {
+	if len(manifest.Layers) != 1 {
+		return remote.LoadedCache{}, errors.Errorf("expected a layer in %s but got %d", tag, len(manifest.Layers))
+	}
+
+	layer, err := m.repo.Fetch(ctx, manifest.Layers[0])
+	if err != nil {
+		return remote.LoadedCache{}, errors.WithStack(err)
+	}
+
+	metadata := metadataOf(manifest)
+	return remote.LoadedCache{
+		Key:      key,
+		Data:     layer,
+		Metadata: metadata,
+		Extra:    metadata.Extra(),
+	}, nil
+}
+
+func metadataOf(manifest ocispec.Manifest) remote.Metadata {
+	metadata := make(remote.Metadata)
+	for annotation, value := range manifest.Annotations {
+		if name, found := strings.CutPrefix(annotation, AnnotationMetadataPrefix); found {
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
+
+// List fetches manifest per tag, because tags do not tell keys, sizes nor creation times.
+func (m Manager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	tags, err := m.listMatchedTags(ctx, prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []remote.Entry
+	for _, tag := range tags {
+		_, manifest, err := m.fetchManifest(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		created, err := time.Parse(time.RFC3339Nano, manifest.Annotations[ocispec.AnnotationCreated])
+		if err != nil {
+			// not created by this manager
+			continue
+		}
+		key := manifest.Annotations[AnnotationCacheKey]
+		if key == "" {
+			key = tag
+		}
+		// tags of different keys may share prefix, because disallowed characters of keys are replaced
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		size := int64(-1)
+		if len(manifest.Layers) == 1 {
+			size = manifest.Layers[0].Size
+		}
+		entries = append(entries, remote.Entry{Key: key, Size: size, Modified: created, Metadata: metadataOf(manifest)})
+	}
+	return entries, nil
+}
+
+// Delete removes the manifest that the tag of key points to, which untags it as well.
+// Layers are left to garbage collection of the registry. Registries may not allow deletion at all.
+func (m Manager) Delete(ctx context.Context, key string) error {
+	desc, manifest, err := m.fetchManifest(ctx, tagFromKey(key))
+	if errors.Is(err, errdef.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// the tag is overwritten by another key that is converted into the same tag
+	if cacheKey := manifest.Annotations[AnnotationCacheKey]; cacheKey != "" && cacheKey != key {
+		return nil
+	}
+
+	err = m.repo.Delete(ctx, desc)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+// listMatchedTags lists tags that start with the tag of one of keys.
+func (m Manager) listMatchedTags(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]string, error) {
+	keys := make([]string, 0, 1+len(secondaryKeys))
+	keys = append(keys, tagFromKey(primaryKey))
+	for _, key := range secondaryKeys {
+		keys = append(keys, tagFromKey(key))
+	}
+
+	var matchedTags []string
+	err := m.repo.Tags(ctx, "", func(tags []string) error {
+		for _, tag := range tags {
+			if slices.ContainsFunc(keys, func(key string) bool { return strings.HasPrefix(tag, key) }) {
+				matchedTags = append(matchedTags, tag)
+			}
+		}
+		return nil
+	})
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) && errResp.StatusCode == http.StatusNotFound {
+		// repository is not created yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return matchedTags, nil
+}
+
+func (m Manager) fetchManifest(ctx context.Context, reference string) (ocispec.Descriptor, ocispec.Manifest, error) {
+	desc, rc, err := m.repo.FetchReference(ctx, reference)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Manifest{}, errors.WithStack(err)
+	}
+	defer rc.Close()
+
+	raw, err := content.ReadAll(rc, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Manifest{}, errors.WithStack(err)
+	}
+
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(raw, &manifest); err != nil {
+		return ocispec.Descriptor{}, ocispec.Manifest{}, errors.WithStack(err)
+	}
+	return desc, manifest, nil
+}
+
+func (m Manager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	sizeHint int64,
+	metadata remote.Metadata,
+) error {
+	return m.SaveWithTrailingMetadata(ctx, cacheKey, data, sizeHint, remote.StaticMetadata(metadata))
+}
+
+// SaveWithTrailingMetadata spools data into a temporary file before uploading,
+// because registry requires digest and size of blob before pushing it.
+// Metadata is stored in annotations of the manifest, which is pushed after the blob.
+func (m Manager) SaveWithTrailingMetadata(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	_ int64,
+	metadata func() (remote.Metadata, error),
+) error {
+	tag := tagFromKey(cacheKey)
+	if len(tag) > maxTagLength {
+		return errors.Errorf("cache key must not be longer than %d: %s", maxTagLength, cacheKey)
+	}
+
+	fp, err := os.CreateTemp("", "buildkit-state-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+	}()
+
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(io.MultiWriter(fp, digester.Hash()), data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err = fp.Seek(0, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	resolved, err := metadata()
+	if err != nil {
+		return err
+	}
+
+	layer := ocispec.Descriptor{
+		MediaType: LayerMediaType,
+		Digest:    digester.Digest(),
+		Size:      size,
+	}
+	exists, err := m.repo.Exists(ctx, layer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !exists {
+		if err = m.repo.Push(ctx, layer, fp); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	annotations := map[string]string{
+		ocispec.AnnotationCreated: time.Now().UTC().Format(time.RFC3339Nano),
+		AnnotationCacheKey:        cacheKey,
+	}
+	for key, value := range resolved {
+		annotations[AnnotationMetadataPrefix+key] = value
+	}
+
+	manifest, err := oras.PackManifest(
+		ctx,
+		m.repo,
+		oras.PackManifestVersion1_0,
+		ArtifactType,
+		oras.PackManifestOptions{
+			Layers:              []ocispec.Descriptor{layer},
+			ManifestAnnotations: annotations,
+		},
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(m.repo.Tag(ctx, manifest, tag))
+}
+
+// tagFromKey converts cacheKey into a valid tag by replacing disallowed characters with `_`.
+// Conversion is done character by character, so a prefix of key is converted into a prefix of its tag.
+func tagFromKey(cacheKey string) string {
+	tag := []byte(cacheKey)
+	for i, c := range tag {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_':
+		case (c == '.' || c == '-') && i != 0:
+		default:
+			tag[i] = '_'
+		}
+	}
+	return string(tag)
+}
+
+var (
+	_ remote.Manager               = Manager{}
+	_ remote.Lister                = Manager{}
+	_ remote.Deleter               = Manager{}
+	_ remote.TrailingMetadataSaver = Manager{}
+)
@@ -0,0 +1,163 @@
+package ocimanager
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/caarlos0/env/v9"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Config struct {
+	Host string `env:"REGISTRY_HOST" envDefault:"localhost"`
+	Port int    `env:"REGISTRY_PORT" envDefault:"5000"`
+}
+
+func newConfig() (cfg Config, err error) {
+	err = errors.WithStack(env.Parse(&cfg))
+	return
+}
+
+func newManager() (Manager, error) {
+	cfg, err := newConfig()
+	if err != nil {
+		return Manager{}, errors.WithStack(err)
+	}
+
+	repository := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)) + "/" + strconv.Itoa(rand.Int()) // nolint:gosec
+	return New(repository, Options{PlainHTTP: true})
+}
+
+func TestManager_LoadTo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		predefinedKeys []string
+		primaryKey     string
+		secondaryKeys  []string
+		found          bool
+		expectedKey    string
+	}{
+		{
+			name:           "empty",
+			predefinedKeys: nil,
+			primaryKey:     "",
+			secondaryKeys:  nil,
+			found:          false,
+			expectedKey:    "",
+		},
+		{
+			name:           "single exact matched object",
+			predefinedKeys: []string{"key-with-dashes"},
+			primaryKey:     "key-with-dashes",
+			secondaryKeys:  nil,
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single exact matched object with disallowed characters",
+			predefinedKeys: []string{"key/with/slashes"},
+			primaryKey:     "key/with/slashes",
+			secondaryKeys:  nil,
+			found:          true,
+			expectedKey:    "key/with/slashes",
+		},
+		{
+			name:           "single exact matched from secondary",
+			predefinedKeys: []string{"key-with-dashes"},
+			primaryKey:     "does-not-exists",
+			secondaryKeys:  []string{"key-with-dashes"},
+			found:          true,
+			expectedKey:    "key-with-dashes",
+		},
+		{
+			name:           "single prefixed matched from secondary",
+			predefinedKeys: []string{"key-with-dashes-and-extra"},
+			primaryKey:     "does-not-exists",
+			secondaryKeys:  []string{"key-with-dashes"},
+			found:          true,
+			expectedKey:    "key-with-dashes-and-extra",
+		},
+		{
+			name: "multiple matches",
+			predefinedKeys: []string{
+				"key-with-dashes-oldest",
+				"key-with-dashes-0",
+				"key-with-dashes-1",
+				"key-with-dashes-newest",
+			},
+			primaryKey:    "does-not-exists",
+			secondaryKeys: []string{"key-with-dashes"},
+			found:         true,
+			expectedKey:   "key-with-dashes-newest",
+		},
+		{
+			name: "multiple matches - prefer exact match",
+			predefinedKeys: []string{
+				"key-with-dashes-oldest",
+				"key-with-dashes-0",
+				"key-with-dashes",
+				"key-with-dashes-1",
+				"key-with-dashes-newest",
+			},
+			primaryKey:    "does-not-exists",
+			secondaryKeys: []string{"key-with-dashes"},
+			found:         true,
+			expectedKey:   "key-with-dashes",
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			manager, err := newManager()
+			require.NoError(t, err)
+
+			for i, key := range tc.predefinedKeys {
+				if i != 0 {
+					time.Sleep(100 * time.Millisecond)
+				}
+				err = manager.Save(ctx, key, strings.NewReader("data-"+key), 0, remote.Metadata{"codec": key})
+				require.NoError(t, err)
+			}
+
+			candidates, err := manager.Load(ctx, tc.primaryKey, tc.secondaryKeys)
+			assert.NoError(t, err)
+			require.Equal(t, tc.found, len(candidates) > 0)
+			if tc.found {
+				assert.Equal(t, tc.expectedKey, candidates[0].Key)
+
+				cache, err := candidates[0].Open(ctx)
+				require.NoError(t, err)
+				defer cache.Data.Close()
+				assert.Equal(t, remote.Metadata{"codec": tc.expectedKey}, cache.Metadata)
+			}
+		})
+	}
+}
+
+func TestManager_Conformance(t *testing.T) {
+	t.Parallel()
+
+	remotetest.TestManager(t, func(t *testing.T) remote.Manager {
+		t.Helper()
+
+		manager, err := newManager()
+		require.NoError(t, err)
+		return manager
+	}, remotetest.Options{SaveInterval: 100 * time.Millisecond})
+}
@@ -0,0 +1,303 @@
+package remotetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+const defaultLargePayloadSize = 16 << 20
+
+// Options tunes TestManager for characteristics of the remote.
+type Options struct {
+	// SaveInterval is slept between saves whose order matters.
+	// It has to be longer than the precision of modification time of the remote.
+	SaveInterval time.Duration
+	// LargePayloadSize is the size of data of the large payload test. 16MiB if zero.
+	LargePayloadSize int
+}
+
+// TestManager is the conformance suite of remote.Manager.
+// newManager has to return a manager that does not have any cache, and is not shared with other calls.
+func TestManager(t *testing.T, newManager func(t *testing.T) remote.Manager, opts Options) {
+	t.Helper()
+
+	if opts.LargePayloadSize == 0 {
+		opts.LargePayloadSize = defaultLargePayloadSize
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+		testRoundTrip(t, newManager(t))
+	})
+	t.Run("missing key", func(t *testing.T) {
+		t.Parallel()
+		testMissingKey(t, newManager(t))
+	})
+	t.Run("overwrite", func(t *testing.T) {
+		t.Parallel()
+		testOverwrite(t, newManager(t))
+	})
+	t.Run("large payload", func(t *testing.T) {
+		t.Parallel()
+		testLargePayload(t, newManager(t), opts.LargePayloadSize)
+	})
+	t.Run("concurrent saves", func(t *testing.T) {
+		t.Parallel()
+		testConcurrentSaves(t, newManager(t))
+	})
+	t.Run("canceled save", func(t *testing.T) {
+		t.Parallel()
+		testCanceledSave(t, newManager(t))
+	})
+	t.Run("list", func(t *testing.T) {
+		t.Parallel()
+		manager := newManager(t)
+		lister, ok := manager.(remote.Lister)
+		if !ok {
+			t.Skip("listing is not supported")
+		}
+		testList(t, manager, lister)
+	})
+	t.Run("delete", func(t *testing.T) {
+		t.Parallel()
+		manager := newManager(t)
+		deleter, ok := manager.(remote.Deleter)
+		if !ok {
+			t.Skip("deletion is not supported")
+		}
+		testDelete(t, manager, deleter)
+	})
+	t.Run("trailing metadata", func(t *testing.T) {
+		t.Parallel()
+		manager := newManager(t)
+		saver, ok := manager.(remote.TrailingMetadataSaver)
+		if !ok {
+			t.Skip("trailing metadata is not supported")
+		}
+		testTrailingMetadata(t, manager, saver)
+	})
+	t.Run("resolution", func(t *testing.T) {
+		t.Parallel()
+		TestResolution(t, newManager, opts.SaveInterval)
+	})
+}
+
+func testRoundTrip(t *testing.T, manager remote.Manager) {
+	t.Helper()
+
+	ctx := context.Background()
+	metadata := remote.Metadata{"codec": "zstd", "window_size": "27"}
+	err := manager.Save(ctx, "key", strings.NewReader("data"), 4, metadata)
+	require.NoError(t, err)
+
+	assertCache(t, manager, "key", []byte("data"), metadata)
+}
+
+func testMissingKey(t *testing.T, manager remote.Manager) {
+	t.Helper()
+
+	ctx := context.Background()
+	err := manager.Save(ctx, "key", strings.NewReader("data"), 0, remote.Metadata{})
+	require.NoError(t, err)
+
+	candidates, err := manager.Load(ctx, "missing", []string{"other"})
+	require.NoError(t, err)
+	assert.Empty(t, candidates)
+}
+
+func testOverwrite(t *testing.T, manager remote.Manager) {
+	t.Helper()
+
+	ctx := context.Background()
+	err := manager.Save(ctx, "key", strings.NewReader("old"), 0, remote.Metadata{"codec": "gzip"})
+	require.NoError(t, err)
+	err = manager.Save(ctx, "key", strings.NewReader("new"), 0, remote.Metadata{"codec": "zstd"})
+	require.NoError(t, err)
+
+	assertCache(t, manager, "key", []byte("new"), remote.Metadata{"codec": "zstd"})
+}
+
+func testLargePayload(t *testing.T, manager remote.Manager, size int) {
+	t.Helper()
+
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	// sizeHint is not exact on purpose, because it is only an estimation.
+	err = manager.Save(context.Background(), "key", bytes.NewReader(data), int64(size/2), remote.Metadata{})
+	require.NoError(t, err)
+
+	assertCache(t, manager, "key", data, remote.Metadata{})
+}
+
+func testConcurrentSaves(t *testing.T, manager remote.Manager) {
+	t.Helper()
+
+	const concurrency = 8
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make([]error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key-" + strconv.Itoa(i)
+			errs[i] = manager.Save(ctx, key, strings.NewReader("data-"+key), 0, remote.Metadata{"codec": key})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	candidates, err := manager.Load(ctx, "missing", []string{"key-"})
+	require.NoError(t, err)
+	assert.Len(t, candidates, concurrency)
+	for i := 0; i < concurrency; i++ {
+		key := "key-" + strconv.Itoa(i)
+		assertCache(t, manager, key, []byte("data-"+key), remote.Metadata{"codec": key})
+	}
+}
+
+func testCanceledSave(t *testing.T, manager remote.Manager) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := io.MultiReader(
+		strings.NewReader("partial"),
+		cancelingReader{cancel},
+		strings.NewReader("rest"),
+	)
+	err := manager.Save(ctx, "key", data, 0, remote.Metadata{})
+	require.Error(t, err)
+
+	candidates, err := manager.Load(context.Background(), "key", nil)
+	require.NoError(t, err)
+	assert.Empty(t, candidates, "canceled save must not leave a cache behind")
+}
+
+// cancelingReader cancels the context in the middle of data, then yields nothing.
+type cancelingReader struct {
+	cancel context.CancelFunc
+}
+
+func (r cancelingReader) Read([]byte) (int, error) {
+	r.cancel()
+	// wait for the cancellation to reach goroutines that the manager spawned.
+	time.Sleep(100 * time.Millisecond)
+	return 0, io.EOF
+}
+
+func testList(t *testing.T, manager remote.Manager, lister remote.Lister) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, key := range []string{"a-1", "a-2", "b-1"} {
+		err := manager.Save(ctx, key, strings.NewReader("data-"+key), 0, remote.Metadata{"codec": key})
+		require.NoError(t, err)
+	}
+
+	entries, err := lister.List(ctx, "a-")
+	require.NoError(t, err)
+	slices.SortFunc(entries, func(a, b remote.Entry) bool { return a.Key < b.Key })
+	require.Len(t, entries, 2)
+	for i, key := range []string{"a-1", "a-2"} {
+		assert.Equal(t, key, entries[i].Key)
+		assert.Equal(t, int64(len("data-"+key)), entries[i].Size)
+		assert.Equal(t, remote.Metadata{"codec": key}, entries[i].Metadata)
+		assert.False(t, entries[i].Modified.IsZero())
+	}
+
+	entries, err = lister.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	entries, err = lister.List(ctx, "missing")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func testDelete(t *testing.T, manager remote.Manager, deleter remote.Deleter) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, key := range []string{"key-1", "key-2"} {
+		require.NoError(t, manager.Save(ctx, key, strings.NewReader("data-"+key), 0, remote.Metadata{"codec": "zstd"}))
+	}
+
+	require.NoError(t, deleter.Delete(ctx, "key-1"))
+	candidates, err := manager.Load(ctx, "key-1", []string{"key-"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key-2"}, Keys(candidates))
+
+	if lister, ok := manager.(remote.Lister); ok {
+		entries, err := lister.List(ctx, "")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "key-2", entries[0].Key)
+	}
+
+	// deleting missing key is not an error
+	assert.NoError(t, deleter.Delete(ctx, "key-1"))
+	assert.NoError(t, deleter.Delete(ctx, "missing"))
+}
+
+func testTrailingMetadata(t *testing.T, manager remote.Manager, saver remote.TrailingMetadataSaver) {
+	t.Helper()
+
+	ctx := context.Background()
+	data := strings.NewReader("data")
+	err := saver.SaveWithTrailingMetadata(ctx, "key", data, 0, func() (remote.Metadata, error) {
+		assert.Zero(t, data.Len(), "metadata must be resolved after data is read to the end")
+		return remote.Metadata{"codec": "zstd", "sha256": "checksum"}, nil
+	})
+	require.NoError(t, err)
+	assertCache(t, manager, "key", []byte("data"), remote.Metadata{"codec": "zstd", "sha256": "checksum"})
+
+	// failure of resolving metadata fails the save without leaving a cache behind
+	err = saver.SaveWithTrailingMetadata(ctx, "other", strings.NewReader("data"), 0, func() (remote.Metadata, error) {
+		return nil, errors.New("data is corrupted")
+	})
+	require.Error(t, err)
+	candidates, err := manager.Load(ctx, "other", nil)
+	require.NoError(t, err)
+	assert.Empty(t, candidates)
+}
+
+func assertCache(t *testing.T, manager remote.Manager, key string, data []byte, metadata remote.Metadata) {
+	t.Helper()
+
+	ctx := context.Background()
+	candidates, err := manager.Load(ctx, key, nil)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, key, candidates[0].Key)
+
+	cache, err := candidates[0].Open(ctx)
+	require.NoError(t, err)
+	defer cache.Data.Close()
+
+	loaded, err := io.ReadAll(cache.Data)
+	require.NoError(t, err)
+	assert.Equal(t, key, cache.Key)
+	assert.Equal(t, data, loaded)
+	assert.Equal(t, metadata, cache.Metadata)
+}
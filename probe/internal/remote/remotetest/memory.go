@@ -0,0 +1,166 @@
+package remotetest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// MemoryManager is a remote.Manager that keeps caches in memory, for tests of code that depends on remote.Manager.
+// It is safe for concurrent use.
+type MemoryManager struct {
+	mu     sync.Mutex
+	caches map[string]memoryCache
+	// clock is incremented on every save, so that modification times never collide.
+	clock time.Time
+}
+
+type memoryCache struct {
+	data     []byte
+	metadata remote.Metadata
+	modified time.Time
+}
+
+func NewMemoryManager() *MemoryManager {
+	return &MemoryManager{
+		caches: make(map[string]memoryCache),
+		clock:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (m *MemoryManager) Load(
+	ctx context.Context,
+	primaryKey string,
+	secondaryKeys []string,
+) ([]remote.Candidate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var candidates []remote.Candidate
+	for key, cache := range m.caches {
+		key, cache := key, cache
+		matched := key == primaryKey ||
+			slices.ContainsFunc(secondaryKeys, func(prefix string) bool { return strings.HasPrefix(key, prefix) })
+		if !matched {
+			continue
+		}
+		candidates = append(candidates, remote.NewCandidate(
+			key,
+			cache.modified,
+			func(ctx context.Context) (remote.LoadedCache, error) {
+				if err := ctx.Err(); err != nil {
+					return remote.LoadedCache{}, errors.WithStack(err)
+				}
+				metadata := maps.Clone(cache.metadata)
+				return remote.LoadedCache{
+					Key:      key,
+					Data:     io.NopCloser(bytes.NewReader(cache.data)),
+					Metadata: metadata,
+					Extra:    metadata.Extra(),
+				}, nil
+			},
+		))
+	}
+
+	return remote.Resolve(candidates, primaryKey, secondaryKeys), nil
+}
+
+func (m *MemoryManager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []remote.Entry
+	for key, cache := range m.caches {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, remote.Entry{
+				Key:      key,
+				Size:     int64(len(cache.data)),
+				Modified: cache.modified,
+				Metadata: maps.Clone(cache.metadata),
+			})
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryManager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	sizeHint int64,
+	metadata remote.Metadata,
+) error {
+	return m.SaveWithTrailingMetadata(ctx, cacheKey, data, sizeHint, remote.StaticMetadata(metadata))
+}
+
+// SaveWithTrailingMetadata reads whole data before storing it, so that failed or canceled saves leave nothing behind.
+func (m *MemoryManager) SaveWithTrailingMetadata(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	_ int64,
+	resolveMetadata func() (remote.Metadata, error),
+) error {
+	buf, err := io.ReadAll(remote.NewContextReader(ctx, data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	metadata, err := resolveMetadata()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = m.clock.Add(time.Second)
+	if metadata == nil {
+		metadata = remote.Metadata{}
+	}
+	m.caches[cacheKey] = memoryCache{buf, maps.Clone(metadata), m.clock}
+	return nil
+}
+
+func (m *MemoryManager) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.caches, key)
+	return nil
+}
+
+// Keys returns keys of all stored caches in no particular order.
+func (m *MemoryManager) Keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return maps.Keys(m.caches)
+}
+
+var (
+	_ remote.Manager               = (*MemoryManager)(nil)
+	_ remote.Lister                = (*MemoryManager)(nil)
+	_ remote.Deleter               = (*MemoryManager)(nil)
+	_ remote.TrailingMetadataSaver = (*MemoryManager)(nil)
+)
@@ -0,0 +1,17 @@
+package remotetest
+
+import (
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+)
+
+func TestMemoryManager(t *testing.T) {
+	t.Parallel()
+
+	TestManager(t, func(t *testing.T) remote.Manager {
+		t.Helper()
+
+		return NewMemoryManager()
+	}, Options{})
+}
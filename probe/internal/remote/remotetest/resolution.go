@@ -0,0 +1,128 @@
+// Package remotetest provides test suites that every remote.Manager has to pass.
+package remotetest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ResolutionCase describes which caches are restored by keys, following remote.Resolve.
+type ResolutionCase struct {
+	Name string
+	// SavedKeys are saved in order, so the latter one is newer.
+	SavedKeys     []string
+	PrimaryKey    string
+	SecondaryKeys []string
+	// ExpectedKeys are keys of candidates in the order to be restored.
+	ExpectedKeys []string
+}
+
+var ResolutionCases = []ResolutionCase{
+	{
+		Name:          "nothing saved",
+		SavedKeys:     nil,
+		PrimaryKey:    "key",
+		SecondaryKeys: []string{"key"},
+		ExpectedKeys:  nil,
+	},
+	{
+		Name:          "exact primary key",
+		SavedKeys:     []string{"key-1", "key-2"},
+		PrimaryKey:    "key-1",
+		SecondaryKeys: nil,
+		ExpectedKeys:  []string{"key-1"},
+	},
+	{
+		Name:          "primary key is not matched by prefix",
+		SavedKeys:     []string{"key-1"},
+		PrimaryKey:    "key",
+		SecondaryKeys: nil,
+		ExpectedKeys:  nil,
+	},
+	{
+		Name:          "primary key before restore keys",
+		SavedKeys:     []string{"key-exact", "key-new"},
+		PrimaryKey:    "key-exact",
+		SecondaryKeys: []string{"key-"},
+		ExpectedKeys:  []string{"key-exact", "key-new"},
+	},
+	{
+		Name:          "exact restore key before newer prefix matches",
+		SavedKeys:     []string{"key", "key-new"},
+		PrimaryKey:    "missing",
+		SecondaryKeys: []string{"key"},
+		ExpectedKeys:  []string{"key", "key-new"},
+	},
+	{
+		Name:          "prefix matches from the newest",
+		SavedKeys:     []string{"key-a", "key-c", "key-b"},
+		PrimaryKey:    "missing",
+		SecondaryKeys: []string{"key-"},
+		ExpectedKeys:  []string{"key-b", "key-c", "key-a"},
+	},
+	{
+		Name:          "restore keys in the given order rather than recency",
+		SavedKeys:     []string{"main-1", "feature-1"},
+		PrimaryKey:    "missing",
+		SecondaryKeys: []string{"main-", "feature-"},
+		ExpectedKeys:  []string{"main-1", "feature-1"},
+	},
+	{
+		Name:          "cache matched by overlapping keys appears once",
+		SavedKeys:     []string{"linux-main-1", "linux-feature-1"},
+		PrimaryKey:    "missing",
+		SecondaryKeys: []string{"linux-main-", "linux-"},
+		ExpectedKeys:  []string{"linux-main-1", "linux-feature-1"},
+	},
+	{
+		Name:          "unrelated caches are ignored",
+		SavedKeys:     []string{"other", "key-1"},
+		PrimaryKey:    "missing",
+		SecondaryKeys: []string{"key-"},
+		ExpectedKeys:  []string{"key-1"},
+	},
+}
+
+// TestResolution saves caches of each of ResolutionCases into an empty manager created by newManager,
+// and checks the candidates that Load returns.
+// saveInterval has to be longer than the precision of modification time of the remote.
+func TestResolution(t *testing.T, newManager func(t *testing.T) remote.Manager, saveInterval time.Duration) {
+	t.Helper()
+
+	for _, tc := range ResolutionCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			manager := newManager(t)
+			for i, key := range tc.SavedKeys {
+				if i != 0 {
+					time.Sleep(saveInterval)
+				}
+				err := manager.Save(ctx, key, strings.NewReader("data-"+key), 0, remote.Metadata{})
+				require.NoError(t, err)
+			}
+
+			candidates, err := manager.Load(ctx, tc.PrimaryKey, tc.SecondaryKeys)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedKeys, Keys(candidates))
+		})
+	}
+}
+
+// Keys returns keys of candidates, or nil if there is no candidate.
+func Keys(candidates []remote.Candidate) []string {
+	var keys []string
+	for _, candidate := range candidates {
+		keys = append(keys, candidate.Key)
+	}
+	return keys
+}
@@ -0,0 +1,48 @@
+package remote
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Resolve sorts candidates in the order to be restored, which follows the matching of actions/cache:
+//
+//  1. the cache whose key is exactly primaryKey
+//  2. for each of secondaryKeys in the given order,
+//     the cache whose key is exactly the restore key, then caches whose key starts with it from the newest
+//
+// Caches of the same modification time are sorted by key in descending order,
+// so that the result does not depend on the order of listing.
+// Candidates that match none of keys are dropped, and each cache appears once at its highest precedence.
+//
+// Managers that list caches by themselves resolve candidates with Resolve,
+// so that the same keys restore the same cache on every remote.
+func Resolve(candidates []Candidate, primaryKey string, secondaryKeys []string) []Candidate {
+	resolved := make([]Candidate, 0, len(candidates))
+	taken := make(map[string]bool, len(candidates))
+	take := func(match func(Candidate) bool) {
+		var matched []Candidate
+		for _, candidate := range candidates {
+			if !taken[candidate.Key] && match(candidate) {
+				taken[candidate.Key] = true
+				matched = append(matched, candidate)
+			}
+		}
+		slices.SortFunc(matched, func(a, b Candidate) bool {
+			if !a.Modified.Equal(b.Modified) {
+				return a.Modified.After(b.Modified)
+			}
+			return a.Key > b.Key
+		})
+		resolved = append(resolved, matched...)
+	}
+
+	take(func(c Candidate) bool { return c.Key == primaryKey })
+	for _, key := range secondaryKeys {
+		key := key
+		take(func(c Candidate) bool { return c.Key == key })
+		take(func(c Candidate) bool { return strings.HasPrefix(c.Key, key) })
+	}
+	return resolved
+}
@@ -0,0 +1,54 @@
+package remote_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range remotetest.ResolutionCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			candidates := make([]remote.Candidate, 0, len(tc.SavedKeys))
+			for i, key := range tc.SavedKeys {
+				candidates = append(candidates, remote.NewCandidate(
+					key,
+					base.Add(time.Duration(i)*time.Second),
+					func(context.Context) (remote.LoadedCache, error) {
+						return remote.LoadedCache{}, nil
+					},
+				))
+			}
+
+			resolved := remote.Resolve(candidates, tc.PrimaryKey, tc.SecondaryKeys)
+			assert.Equal(t, tc.ExpectedKeys, remotetest.Keys(resolved))
+		})
+	}
+}
+
+func TestResolve_Deterministic(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)
+	candidate := func(key string) remote.Candidate {
+		return remote.NewCandidate(key, modified, func(context.Context) (remote.LoadedCache, error) {
+			return remote.LoadedCache{}, nil
+		})
+	}
+
+	forward := remote.Resolve([]remote.Candidate{candidate("key-a"), candidate("key-b")}, "missing", []string{"key-"})
+	backward := remote.Resolve([]remote.Candidate{candidate("key-b"), candidate("key-a")}, "missing", []string{"key-"})
+	assert.Equal(t, []string{"key-b", "key-a"}, remotetest.Keys(forward))
+	assert.Equal(t, remotetest.Keys(forward), remotetest.Keys(backward))
+}
@@ -0,0 +1,151 @@
+package s3manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+const partMaxAttempts = 3
+
+type part struct {
+	data []byte
+	err  error
+}
+
+// rangeReader downloads an object with concurrent ranged requests and returns parts in order.
+// At most `concurrency` parts are kept in memory at once.
+type rangeReader struct {
+	cancel context.CancelFunc
+	parts  chan chan part
+	slots  chan struct{}
+	buf    []byte
+	err    error
+}
+
+func newRangeReader(
+	ctx context.Context,
+	client *s3.Client,
+	bucket, key, eTag string,
+	size, partSize int64,
+	concurrency int,
+) *rangeReader {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &rangeReader{
+		cancel: cancel,
+		parts:  make(chan chan part, concurrency),
+		slots:  make(chan struct{}, concurrency),
+	}
+
+	go func() {
+		defer close(r.parts)
+
+		for offset := int64(0); offset < size; offset += partSize {
+			select {
+			case r.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			length := partSize
+			if offset+length > size {
+				length = size - offset
+			}
+			result := make(chan part, 1)
+			r.parts <- result
+
+			go func(offset, length int64) {
+				data, err := downloadPart(ctx, client, bucket, key, eTag, offset, length)
+				result <- part{data, err}
+			}(offset, length)
+		}
+	}()
+
+	return r
+}
+
+func downloadPart(
+	ctx context.Context,
+	client *s3.Client,
+	bucket, key, eTag string,
+	offset, length int64,
+) (data []byte, err error) {
+	rng := fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	data = make([]byte, length)
+
+	// retry whole part because the client only retries failures of request, not of reading body.
+	for attempt := 0; attempt < partMaxAttempts; attempt++ {
+		var object *s3.GetObjectOutput
+		object, err = client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:  &bucket,
+			Key:     &key,
+			Range:   &rng,
+			IfMatch: &eTag,
+		})
+		if err != nil {
+			err = errors.WithStack(err)
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		_, err = io.ReadFull(object.Body, data)
+		_ = object.Body.Close()
+		if err == nil {
+			return data, nil
+		}
+		err = errors.Wrapf(err, "failed to read range %s of %s", rng, key)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+func (r *rangeReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		result, ok := <-r.parts
+		if !ok {
+			r.err = io.EOF
+			return 0, r.err
+		}
+		p := <-result
+		<-r.slots
+		if p.err != nil {
+			r.err = p.err
+			r.cancel()
+			return 0, r.err
+		}
+		r.buf = p.data
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *rangeReader) Close() error {
+	r.cancel()
+	if r.err == nil {
+		r.err = errors.New("read from closed reader")
+	}
+	// drain so that producer can exit
+	go func() {
+		for result := range r.parts {
+			<-result
+			<-r.slots
+		}
+	}()
+	return nil
+}
+
+var _ io.ReadCloser = (*rangeReader)(nil)
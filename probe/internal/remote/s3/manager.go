@@ -1,31 +1,47 @@
 package s3manager
 
 import (
-	"bytes"
 	"context"
+	"io"
 	"path"
-	"path/filepath"
+	"strings"
 
 	"github.com/isac322/buildkit-state/probe/internal/remote"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/pkg/errors"
-	"github.com/samber/mo"
 	"golang.org/x/exp/slices"
 	"golang.org/x/sync/errgroup"
 )
 
-const version = "v1"
+const (
+	version = "v1"
+
+	MinPartSize        = manager.MinUploadPartSize
+	DefaultPartSize    = 16 * 1024 * 1024
+	DefaultConcurrency = 8
+)
 
 type Manager struct {
 	client    *s3.Client
 	bucket    string
 	keyPrefix string
+	transfer  TransferOptions
+}
+
+// TransferOptions tunes multipart uploads and ranged downloads.
+// Zero values are replaced with DefaultPartSize and DefaultConcurrency.
+type TransferOptions struct {
+	// PartSize is the size of each part of multipart upload and of each ranged request on download.
+	PartSize int64
+	// Concurrency is the number of parts that are transferred in parallel.
+	Concurrency int
 }
 
-func New(cfg aws.Config, bucket, keyPrefix string, s3Compatible bool) Manager {
+func New(cfg aws.Config, bucket, keyPrefix string, s3Compatible bool, transfer TransferOptions) Manager {
 	client := s3.NewFromConfig(
 		cfg,
 		func(options *s3.Options) {
@@ -34,62 +50,110 @@ func New(cfg aws.Config, bucket, keyPrefix string, s3Compatible bool) Manager {
 			}
 		},
 	)
-	return Manager{client, bucket, keyPrefix}
+	if transfer.PartSize <= 0 {
+		transfer.PartSize = DefaultPartSize
+	}
+	if transfer.Concurrency <= 0 {
+		transfer.Concurrency = DefaultConcurrency
+	}
+	return Manager{client, bucket, keyPrefix, transfer}
 }
 
 func (m Manager) Load(
 	ctx context.Context,
 	primaryKey string,
 	secondaryKeys []string,
-) (mo.Option[remote.LoadedCache], error) {
-	result, err := m.loadLatestOrExactMatch(ctx, primaryKey, secondaryKeys)
+) ([]remote.Candidate, error) {
+	objects, err := m.listMatches(ctx, primaryKey, secondaryKeys)
 	if err != nil {
-		return mo.None[remote.LoadedCache](), err
-	}
-	metadata, found := result.Get()
-	if !found {
-		return mo.None[remote.LoadedCache](), nil
+		return nil, err
 	}
 
-	object, err := m.client.GetObject(
-		ctx,
-		&s3.GetObjectInput{
-			Bucket: &m.bucket,
-			Key:    metadata.Key,
-		},
-	)
-	if err != nil {
-		return mo.None[remote.LoadedCache](), errors.WithStack(err)
+	candidates := make([]remote.Candidate, 0, len(objects))
+	for _, object := range objects {
+		object := object
+		rel := m.relKey(*object.Key)
+		candidates = append(candidates, remote.NewCandidate(
+			rel,
+			aws.ToTime(object.LastModified),
+			func(ctx context.Context) (remote.LoadedCache, error) {
+				return m.open(ctx, object, rel)
+			},
+		))
 	}
 
-	rel, err := filepath.Rel(version, *metadata.Key)
-	if err != nil {
-		return mo.None[remote.LoadedCache](), errors.Wrap(err, "version does not match")
+	return remote.Resolve(candidates, primaryKey, secondaryKeys), nil
+}
+
+func (m Manager) open(ctx context.Context, object types.Object, key string) (remote.LoadedCache, error) {
+	var body io.ReadCloser
+	var userMetadata map[string]string
+	if object.Size <= m.transfer.PartSize {
+		result, err := m.client.GetObject(
+			ctx,
+			&s3.GetObjectInput{
+				Bucket:  &m.bucket,
+				Key:     object.Key,
+				IfMatch: object.ETag,
+			},
+		)
+		if err != nil {
+			return remote.LoadedCache{}, errors.WithStack(err)
+		}
+		body = result.Body
+		userMetadata = result.Metadata
+	} else {
+		// listing does not include user metadata
+		head, err := m.client.HeadObject(
+			ctx,
+			&s3.HeadObjectInput{
+				Bucket:  &m.bucket,
+				Key:     object.Key,
+				IfMatch: object.ETag,
+			},
+		)
+		if err != nil {
+			return remote.LoadedCache{}, errors.WithStack(err)
+		}
+		userMetadata = head.Metadata
+
+		body = newRangeReader(
+			ctx,
+			m.client,
+			m.bucket,
+			*object.Key,
+			aws.ToString(object.ETag),
+			object.Size,
+			m.transfer.PartSize,
+			m.transfer.Concurrency,
+		)
 	}
-	return mo.Some(remote.LoadedCache{
-		Key:   rel,
-		Data:  object.Body,
-		Extra: nil,
-	}), nil
+
+	normalized := normalizeMetadata(userMetadata)
+	return remote.LoadedCache{
+		Key:      key,
+		Data:     body,
+		Metadata: normalized,
+		Extra:    normalized.Extra(),
+	}, nil
 }
 
-func (m Manager) loadLatestOrExactMatch(
+// listMatches lists every object whose key starts with one of keys.
+func (m Manager) listMatches(
 	ctx context.Context,
 	primaryKey string,
 	secondaryKeys []string,
-) (mo.Option[types.Object], error) {
-	errGrp, grpCtx := errgroup.WithContext(ctx)
-
-	c := make(chan *s3.ListObjectsV2Output)
-
+) ([]types.Object, error) {
 	keys := make([]string, 0, 1+len(secondaryKeys))
 	keys = append(keys, m.buildS3Key(primaryKey))
 	for _, key := range secondaryKeys {
 		keys = append(keys, m.buildS3Key(key))
 	}
 
-	for _, key := range keys {
-		key := key
+	errGrp, grpCtx := errgroup.WithContext(ctx)
+	listed := make([][]types.Object, len(keys))
+	for i, key := range keys {
+		i, key := i, key
 		errGrp.Go(func() error {
 			paginator := s3.NewListObjectsV2Paginator(
 				m.client,
@@ -103,64 +167,111 @@ func (m Manager) loadLatestOrExactMatch(
 				if err != nil {
 					return errors.WithStack(err)
 				}
-
-				select {
-				case c <- page:
-				case <-grpCtx.Done():
-					return grpCtx.Err()
-				}
+				listed[i] = append(listed[i], page.Contents...)
 			}
 			return nil
 		})
 	}
+	if err := errGrp.Wait(); err != nil {
+		return nil, err
+	}
 
-	var err error
-	go func() {
-		err = errGrp.Wait()
-		close(c)
-	}()
-
-	var result mo.Option[types.Object]
-	exactMatchFound := false
-	for obj := range c {
-		if exactMatchFound {
-			continue
-		}
-
-		for _, content := range obj.Contents {
-			if slices.Contains(keys, *content.Key) {
-				exactMatchFound = true
-				result = mo.Some(content)
-				break
-			}
-
-			if prev, found := result.Get(); found {
-				if prev.LastModified.IsZero() || prev.LastModified.Before(*content.LastModified) {
-					result = mo.Some(content)
-				}
-			} else {
-				result = mo.Some(content)
+	// an object is listed multiple times if keys overlap
+	var objects []types.Object
+	for _, page := range listed {
+		for _, object := range page {
+			if !slices.ContainsFunc(objects, func(o types.Object) bool { return *o.Key == *object.Key }) {
+				objects = append(objects, object)
 			}
 		}
 	}
-	if err != nil {
-		return mo.None[types.Object](), errors.WithStack(err)
-	}
-
-	return result, nil
+	return objects, nil
 }
 
-func (m Manager) Save(ctx context.Context, cacheKey string, data []byte) error {
+func (m Manager) Save(
+	ctx context.Context,
+	cacheKey string,
+	data io.Reader,
+	sizeHint int64,
+	metadata remote.Metadata,
+) error {
 	key := m.buildS3Key(cacheKey)
-	_, err := m.client.PutObject(
+	uploader := manager.NewUploader(m.client, func(u *manager.Uploader) {
+		u.PartSize = m.transfer.PartSize
+		u.Concurrency = m.transfer.Concurrency
+		// grow part size so that estimated size fits in the limit of the number of parts
+		if partSize := sizeHint / int64(manager.MaxUploadParts); partSize > u.PartSize {
+			u.PartSize = partSize + 1
+		}
+		// a failed part is retried on its own instead of restarting whole upload
+		u.ClientOptions = append(u.ClientOptions, func(options *s3.Options) {
+			options.RetryMaxAttempts = partMaxAttempts
+		})
+	})
+	_, err := uploader.Upload(
 		ctx,
-		&s3.PutObjectInput{Bucket: &m.bucket, Key: &key, Body: bytes.NewReader(data)},
+		&s3.PutObjectInput{Bucket: &m.bucket, Key: &key, Body: data, Metadata: metadata},
 	)
 	return errors.WithStack(err)
 }
 
+// normalizeMetadata lowercases keys of user metadata, because some S3 compatible storages canonicalize them.
+func normalizeMetadata(userMetadata map[string]string) remote.Metadata {
+	metadata := make(remote.Metadata, len(userMetadata))
+	for key, value := range userMetadata {
+		metadata[strings.ToLower(key)] = value
+	}
+	return metadata
+}
+
+// List issues HEAD request per object, because listing does not include user metadata.
+func (m Manager) List(ctx context.Context, prefix string) ([]remote.Entry, error) {
+	listPrefix := m.buildS3Key("") + "/" + prefix
+	paginator := s3.NewListObjectsV2Paginator(m.client, &s3.ListObjectsV2Input{Bucket: &m.bucket, Prefix: &listPrefix})
+
+	var entries []remote.Entry
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, object := range page.Contents {
+			head, err := m.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &m.bucket, Key: object.Key})
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			entries = append(entries, remote.Entry{
+				Key:      m.relKey(*object.Key),
+				Size:     object.Size,
+				Modified: aws.ToTime(object.LastModified),
+				Metadata: normalizeMetadata(head.Metadata),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// Delete does not fail on missing key, because S3 does not tell whether the object existed.
+func (m Manager) Delete(ctx context.Context, key string) error {
+	_, err := m.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &m.bucket,
+		Key:    aws.String(m.buildS3Key(key)),
+	})
+	return errors.WithStack(err)
+}
+
 func (m Manager) buildS3Key(key string) string {
 	return path.Join(version, m.keyPrefix, key)
 }
 
-var _ remote.Manager = Manager{}
+// relKey is the key of caches returned by Load and List, which is relative to the version and the key prefix,
+// so that it can be given back to Load, Save and Delete as is.
+func (m Manager) relKey(objectKey string) string {
+	return strings.TrimPrefix(objectKey, m.buildS3Key("")+"/")
+}
+
+var (
+	_ remote.Manager = Manager{}
+	_ remote.Lister  = Manager{}
+	_ remote.Deleter = Manager{}
+)
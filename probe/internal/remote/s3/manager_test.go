@@ -3,6 +3,7 @@ package s3manager
 import (
 	"bytes"
 	"context"
+	"io"
 	"math/rand"
 	"net"
 	"os"
@@ -10,6 +11,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
@@ -102,7 +106,7 @@ func TestManager_LoadTo(t *testing.T) {
 			primaryKey:     "key-with-dashes",
 			secondaryKeys:  nil,
 			found:          true,
-			expectedKey:    "prefixed/key-with-dashes",
+			expectedKey:    "key-with-dashes",
 		},
 		{
 			name:           "single exact matched from secondary",
@@ -111,7 +115,7 @@ func TestManager_LoadTo(t *testing.T) {
 			primaryKey:     "does-not-exists",
 			secondaryKeys:  []string{"key-with-dashes"},
 			found:          true,
-			expectedKey:    "prefixed/key-with-dashes",
+			expectedKey:    "key-with-dashes",
 		},
 		{
 			name:           "single prefixed matched from secondary",
@@ -120,7 +124,7 @@ func TestManager_LoadTo(t *testing.T) {
 			primaryKey:     "does-not-exists",
 			secondaryKeys:  []string{"key-with-dashes"},
 			found:          true,
-			expectedKey:    "prefixed/key-with-dashes-and-extra",
+			expectedKey:    "key-with-dashes-and-extra",
 		},
 		{
 			name: "multiple matches",
@@ -134,7 +138,7 @@ func TestManager_LoadTo(t *testing.T) {
 			primaryKey:    "does-not-exists",
 			secondaryKeys: []string{"key-with-dashes"},
 			found:         true,
-			expectedKey:   "prefixed/key-with-dashes-newest",
+			expectedKey:   "key-with-dashes-newest",
 		},
 		{
 			name: "multiple matches - prefer exact match",
@@ -149,7 +153,7 @@ func TestManager_LoadTo(t *testing.T) {
 			primaryKey:    "does-not-exists",
 			secondaryKeys: []string{"key-with-dashes"},
 			found:         true,
-			expectedKey:   "prefixed/key-with-dashes",
+			expectedKey:   "key-with-dashes",
 		},
 	}
 	for _, tc := range tests {
@@ -163,7 +167,7 @@ func TestManager_LoadTo(t *testing.T) {
 			require.NoError(t, err)
 
 			bucket := strconv.Itoa(rand.Int()) // nolint:gosec
-			manager := New(awsConfig, bucket, tc.keyPrefix, true)
+			manager := New(awsConfig, bucket, tc.keyPrefix, true, TransferOptions{})
 
 			_, err = manager.client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &bucket})
 			require.NoError(t, err)
@@ -184,11 +188,72 @@ func TestManager_LoadTo(t *testing.T) {
 				require.NoError(t, errors.WithStack(err))
 			}
 
-			result, err := manager.Load(ctx, tc.primaryKey, tc.secondaryKeys)
+			candidates, err := manager.Load(ctx, tc.primaryKey, tc.secondaryKeys)
 			assert.NoError(t, err)
-			cache, found := result.Get()
-			assert.Equal(t, tc.found, found)
-			assert.Equal(t, tc.expectedKey, cache.Key)
+			require.Equal(t, tc.found, len(candidates) > 0)
+			if tc.found {
+				assert.Equal(t, tc.expectedKey, candidates[0].Key)
+			}
+		})
+	}
+}
+
+func TestManager_SaveAndLoadMultipart(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	awsConfig, err := newAWSConfig(ctx)
+	require.NoError(t, err)
+
+	bucket := strconv.Itoa(rand.Int()) // nolint:gosec
+	manager := New(awsConfig, bucket, "", true, TransferOptions{PartSize: MinPartSize, Concurrency: 2})
+
+	_, err = manager.client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &bucket})
+	require.NoError(t, err)
+
+	data := make([]byte, MinPartSize*7/2)
+	_, err = rand.Read(data) // nolint:gosec
+	require.NoError(t, err)
+
+	metadata := remote.Metadata{"codec": "zstd"}
+	err = manager.Save(ctx, "key", bytes.NewReader(data), 0, metadata)
+	require.NoError(t, err)
+
+	candidates, err := manager.Load(ctx, "key", nil)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	cache, err := candidates[0].Open(ctx)
+	require.NoError(t, err)
+	defer cache.Data.Close()
+
+	loaded, err := io.ReadAll(cache.Data)
+	require.NoError(t, err)
+	assert.Equal(t, data, loaded)
+	assert.Equal(t, metadata, cache.Metadata)
+}
+
+func TestManager_Conformance(t *testing.T) {
+	t.Parallel()
+
+	for _, keyPrefix := range []string{"", "prefixed"} {
+		keyPrefix := keyPrefix
+		t.Run("key prefix "+strconv.Quote(keyPrefix), func(t *testing.T) {
+			t.Parallel()
+
+			remotetest.TestManager(t, func(t *testing.T) remote.Manager {
+				t.Helper()
+
+				ctx := context.Background()
+				awsConfig, err := newAWSConfig(ctx)
+				require.NoError(t, err)
+
+				bucket := strconv.Itoa(rand.Int()) // nolint:gosec
+				manager := New(awsConfig, bucket, keyPrefix, true, TransferOptions{})
+				_, err = manager.client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &bucket})
+				require.NoError(t, err)
+				return manager
+			}, remotetest.Options{SaveInterval: 100 * time.Millisecond})
 		})
 	}
 }
@@ -0,0 +1,27 @@
+package remote
+
+import (
+	"context"
+	"io"
+)
+
+// TrailingMetadataSaver is implemented by Manager that stores metadata only after it reads the whole data,
+// so that metadata derived from data (e.g. checksum) does not have to be computed ahead of saving.
+type TrailingMetadataSaver interface {
+	// SaveWithTrailingMetadata is Manager.Save whose metadata is given by metadata,
+	// which is called only after data is read to the end.
+	SaveWithTrailingMetadata(
+		ctx context.Context,
+		cacheKey string,
+		data io.Reader,
+		sizeHint int64,
+		metadata func() (Metadata, error),
+	) error
+}
+
+// StaticMetadata adapts metadata that is already known for TrailingMetadataSaver.
+func StaticMetadata(metadata Metadata) func() (Metadata, error) {
+	return func() (Metadata, error) {
+		return metadata, nil
+	}
+}
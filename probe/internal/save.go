@@ -1,113 +1,241 @@
 package internal
 
 import (
-	"bytes"
 	"context"
+	"io"
 	"strconv"
 
 	"github.com/isac322/buildkit-state/probe/internal/buildkit"
-	gha2 "github.com/isac322/buildkit-state/probe/internal/gha"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
 	"github.com/isac322/buildkit-state/probe/internal/remote"
 
 	"github.com/pkg/errors"
-	"github.com/sethvargo/go-githubactions"
+	"golang.org/x/exp/maps"
 )
 
 func SaveFromContainerToRemote(
 	ctx context.Context,
-	gha *githubactions.Action,
-	bkCli buildkit.Driver,
+	runner ci.CI,
+	nodes []buildkit.Node,
 	manager remote.Manager,
 ) (err error) {
 	defer func() {
 		if err != nil {
-			gha.Errorf("Failed to load/save buildkit state: %+v", err)
+			runner.Errorf("Failed to load/save buildkit state: %+v", err)
 		}
 	}()
 
-	if gha.Getenv("RUNNER_DEBUG") == "1" {
+	for _, node := range nodes {
+		err = saveFromNode(ctx, runner, newNodeScope(nodes, node), node, manager)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func saveFromNode(
+	ctx context.Context,
+	runner ci.CI,
+	scope nodeScope,
+	bkCli buildkit.Driver,
+	manager remote.Manager,
+) (err error) {
+	if runner.IsDebug() {
 		usage, err := bkCli.PrintDiskUsage(ctx)
 		if err != nil {
-			gha.Errorf("Failed to print disk usage: %+v", err)
+			runner.Errorf("Failed to print disk usage: %+v", err)
 			return err
 		}
 
-		gha.Debugf(string(usage))
+		runner.Debugf(string(usage))
 	}
 
-	cacheKey := gha.GetInput(inputPrimaryKey)
-	restoredCacheKey := gha.Getenv("STATE_" + stateLoadedCacheKey)
-	gha.Infof("restoredCacheKey: %s, cacheKey: %s", restoredCacheKey, cacheKey)
+	cacheKey := scope.key(runner.GetInput(InputPrimaryKey))
+	restoredCacheKey := runner.GetState(scope.name(stateLoadedCacheKey))
+	runner.Infof("restoredCacheKey: %s, cacheKey: %s", restoredCacheKey, cacheKey)
 
 	if cacheKey == restoredCacheKey {
-		rewriteCache, err := strconv.ParseBool(gha.GetInput(inputRewriteCache))
+		rewriteCache, err := strconv.ParseBool(runner.GetInput(InputRewriteCache))
 		if err != nil {
-			gha.Errorf(`Failed to parse "%s": %+v`, inputRewriteCache, err)
+			runner.Errorf(`Failed to parse "%s": %+v`, InputRewriteCache, err)
 			return errors.WithStack(err)
 		}
 		if !rewriteCache {
-			gha.Infof("Cache key matched. Ignore cache saving.")
+			runner.Infof("Cache key matched. Ignore cache saving.")
 			return nil
 		}
 	}
 
+	if err = pruneUnwanted(ctx, runner, scope, bkCli); err != nil {
+		return err
+	}
+
 	func() {
-		gha.Group("Remove unwanted caches")
-		defer gha.EndGroup()
+		runner.Group(scope.title("Save buildkit state to remote"))
+		defer runner.EndGroup()
 
-		targetTypes := gha2.GetMultilineInput(gha, inputTargetTypes)
-		err = bkCli.PruneExcept(ctx, targetTypes)
+		var state compressedState
+		state, err = extractState(ctx, runner, bkCli)
 		if err != nil {
-			gha.Errorf(`Failed to prune caches: %+v`, err)
 			return
 		}
+		defer state.Close()
 
-		var usage []byte
-		usage, err = bkCli.PrintDiskUsage(ctx)
+		err = saveState(ctx, runner, manager, cacheKey, state)
 		if err != nil {
-			gha.Errorf("Failed to print disk usage: %+v", err)
+			runner.Errorf("Failed to save compressed buildkit sate to remote: %+v", err)
 			return
 		}
-		gha.Infof(string(usage))
 	}()
+
+	return err
+}
+
+// pruneUnwanted removes caches of types other than `target-types` input and prints disk usage of the rest.
+func pruneUnwanted(ctx context.Context, runner ci.CI, scope nodeScope, bkCli buildkit.Driver) error {
+	runner.Group(scope.title("Remove unwanted caches"))
+	defer runner.EndGroup()
+
+	targetTypes := ci.GetMultilineInput(runner, InputTargetTypes)
+	if err := bkCli.PruneExcept(ctx, targetTypes); err != nil {
+		runner.Errorf(`Failed to prune caches: %+v`, err)
+		return err
+	}
+
+	usage, err := bkCli.PrintDiskUsage(ctx)
 	if err != nil {
+		runner.Errorf("Failed to print disk usage: %+v", err)
 		return err
 	}
+	runner.Infof(string(usage))
+	return nil
+}
 
-	func() {
-		gha.Group("Save buildkit state to remote")
-		defer gha.EndGroup()
+// compressedState is compressed buildkit state that is being streamed out of buildkitd.
+// Its checksum, and so its metadata, is known only after it is read to the end.
+type compressedState struct {
+	*checksumReader
+	io.Closer
+	sizeHint int64
+	// partialMetadata is the metadata except checksum.
+	partialMetadata remote.Metadata
+}
 
-		gha.Infof("Stopping buildkitd...")
-		err = bkCli.Stop(ctx)
-		if err != nil {
-			gha.Errorf("Failed to stop buildkitd container: %+v", err)
-			return
-		}
+// metadata returns metadata that describes the state. It fails if the state is not read to the end yet.
+func (s compressedState) metadata() (remote.Metadata, error) {
+	checksum, err := s.checksum()
+	if err != nil {
+		return nil, err
+	}
+	metadata := maps.Clone(s.partialMetadata)
+	metadata[metadataChecksum] = checksum
+	return metadata, nil
+}
 
-		var compressionLevel int
-		compressionLevel, err = strconv.Atoi(gha.GetInput(inputCompressionLevel))
-		if err != nil {
-			gha.Errorf(`Failed to parse "%s": %+v`, inputCompressionLevel, err)
-			err = errors.WithStack(err)
-			return
-		}
+// extractState stops buildkitd, and streams its compressed state.
+// The returned state must be closed by caller.
+func extractState(ctx context.Context, runner ci.CI, bkCli buildkit.Driver) (compressedState, error) {
+	// invalid inputs must fail before buildkitd is stopped
+	opts, err := compressOptions(runner)
+	if err != nil {
+		return compressedState{}, err
+	}
 
-		gha.Infof("Extract and compress buildkit state...")
-		var buf *bytes.Buffer
-		buf, err = CompressToZstd(ctx, bkCli, compressionLevel)
+	stateDir, err := resolveStateDir(ctx, runner, bkCli)
+	if err != nil {
+		return compressedState{}, err
+	}
+
+	info, err := bkCli.DaemonInfo(ctx)
+	if err != nil {
+		runner.Errorf("Failed to query buildkitd: %+v", err)
+		return compressedState{}, err
+	}
+	metadata := opts.Metadata()
+	if err = metadata.SetManifest(newManifest(info, ci.GetMultilineInput(runner, InputTargetTypes))); err != nil {
+		runner.Errorf("Failed to encode manifest: %+v", err)
+		return compressedState{}, err
+	}
+
+	runner.Infof("Stopping buildkitd...")
+	err = bkCli.Stop(ctx)
+	if err != nil {
+		runner.Errorf("Failed to stop buildkitd container: %+v", err)
+		return compressedState{}, err
+	}
+
+	runner.Infof("Extract and compress (%s) buildkit state...", opts.Codec)
+	compressed, sizeHint, err := Compress(ctx, bkCli, stateDir, opts)
+	if err != nil {
+		runner.Errorf("Failed to compress buildkit state: %+v", err)
+		return compressedState{}, err
+	}
+	return compressedState{
+		checksumReader:  newChecksumReader(compressed),
+		Closer:          compressed,
+		sizeHint:        sizeHint,
+		partialMetadata: metadata,
+	}, nil
+}
+
+// saveState streams state into manager.
+// Remotes that send metadata ahead of data get the spooled state, because checksum is known only at the end.
+func saveState(
+	ctx context.Context,
+	runner ci.CI,
+	manager remote.Manager,
+	cacheKey string,
+	state compressedState,
+) error {
+	if saver, ok := manager.(remote.TrailingMetadataSaver); ok {
+		return saver.SaveWithTrailingMetadata(ctx, cacheKey, state, state.sizeHint, state.metadata)
+	}
+
+	runner.Debugf("spooling compressed state, because the remote requires metadata before data")
+	spooled, err := spool(state)
+	if err != nil {
+		return err
+	}
+	defer spooled.Close()
+	metadata, err := state.metadata()
+	if err != nil {
+		return err
+	}
+	return manager.Save(ctx, cacheKey, spooled, spooled.size, metadata)
+}
+
+// compressOptions parses and validates `compression`, `compression-level` and `window-size` inputs.
+func compressOptions(runner ci.CI) (CompressOptions, error) {
+	compressionLevel, err := strconv.Atoi(runner.GetInput(InputCompressionLevel))
+	if err != nil {
+		runner.Errorf(`Failed to parse "%s": %+v`, InputCompressionLevel, err)
+		return CompressOptions{}, errors.WithStack(err)
+	}
+
+	codec := CodecZstd
+	if rawCodec := runner.GetInput(InputCompression); rawCodec != "" {
+		codec, err = ParseCodec(rawCodec)
 		if err != nil {
-			gha.Errorf("Failed to compress buildkit state: %+v", err)
-			return
+			runner.Errorf(`Failed to parse "%s": %+v`, InputCompression, err)
+			return CompressOptions{}, err
 		}
+	}
 
-		gha.Infof("Uploading to remote storage...")
-		err = manager.Save(ctx, cacheKey, buf.Bytes())
+	windowLog := DefaultWindowLog
+	if rawWindowLog := runner.GetInput(InputWindowSize); rawWindowLog != "" {
+		windowLog, err = strconv.Atoi(rawWindowLog)
 		if err != nil {
-			gha.Errorf("Failed to save compressed buildkit sate to remote: %+v", err)
-			return
+			runner.Errorf(`Failed to parse "%s": %+v`, InputWindowSize, err)
+			return CompressOptions{}, errors.WithStack(err)
 		}
-	}()
+	}
 
-	return err
+	opts := CompressOptions{Codec: codec, Level: compressionLevel, WindowLog: windowLog}
+	if err = opts.validate(); err != nil {
+		runner.Errorf("Invalid compression options: %+v", err)
+		return CompressOptions{}, err
+	}
+	return opts, nil
 }
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+)
+
+// Entries of the state directory that are used while restoring.
+// They are placed inside of the state directory rather than next to it,
+// because the state directory is usually a mount point which can not be renamed,
+// and entries on the same filesystem are moved without copying.
+const (
+	stagingDirName = ".buildkit-state-staging"
+	backupDirName  = ".buildkit-state-backup"
+	// swappingMarkName exists while entries of staging directory are being moved into the state directory,
+	// which tells that every entry outside of backup directory is from the staging directory.
+	swappingMarkName = ".buildkit-state-swapping"
+)
+
+// stagedRestore extracts state into a staging directory and swaps it with the current state only on success,
+// so that failure in the middle of restoring does not leave broken state.
+type stagedRestore struct {
+	bkCli    buildkit.Driver
+	stateDir string
+}
+
+func newStagedRestore(bkCli buildkit.Driver, stateDir string) stagedRestore {
+	return stagedRestore{bkCli, stateDir}
+}
+
+func (s stagedRestore) stagingDir() string {
+	return path.Join(s.stateDir, stagingDirName)
+}
+
+// prepare cleans up the staging directory.
+// It also recovers the previous state if the last restore was interrupted while swapping.
+func (s stagedRestore) prepare(ctx context.Context) error {
+	return s.rollback(ctx)
+}
+
+// commit replaces the current state with the staged one.
+func (s stagedRestore) commit(ctx context.Context) error {
+	return s.bkCli.Shell(ctx, s.script(
+		"set -e",
+		"cd {state}",
+		"rm -f {mark}",
+		"mkdir -p {backup}",
+		`find . -mindepth 1 -maxdepth 1 ! -name {staging} ! -name {backup} -exec mv {} {backup}/ \;`,
+		"touch {mark}",
+		`find {staging} -mindepth 1 -maxdepth 1 -exec mv {} ./ \;`,
+		"mv {backup} {staging}/previous",
+		"rm -f {mark}",
+		// the new state is in place, so failure of cleanup is left to the next restore
+		"rm -rf {staging} || true",
+	))
+}
+
+// rollback restores the state that was moved into the backup directory and removes the staging directory.
+func (s stagedRestore) rollback(ctx context.Context) error {
+	return s.bkCli.Shell(ctx, s.script(
+		"set -e",
+		"cd {state}",
+		"if [ -d {backup} ]; then",
+		"  if [ -e {mark} ]; then",
+		`    find . -mindepth 1 -maxdepth 1 ! -name {staging} ! -name {backup} ! -name {mark} -exec rm -rf {} \;`,
+		"  fi",
+		`  find {backup} -mindepth 1 -maxdepth 1 -exec mv {} ./ \;`,
+		"  rmdir {backup}",
+		"fi",
+		"rm -rf {staging} {mark}",
+	))
+}
+
+func (s stagedRestore) script(lines ...string) string {
+	replacer := strings.NewReplacer(
+		"{state}", shellQuote(s.stateDir),
+		"{staging}", stagingDirName,
+		"{backup}", backupDirName,
+		"{mark}", swappingMarkName,
+	)
+	return replacer.Replace(strings.Join(lines, "\n"))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// localShellDriver runs scripts on the local filesystem, like the remote driver does.
+type localShellDriver struct {
+	buildkit.Driver
+}
+
+func (localShellDriver) Shell(ctx context.Context, script string) error {
+	output, err := exec.CommandContext(ctx, "sh", "-c", script).CombinedOutput()
+	if err != nil {
+		return pkgerrors.Wrap(err, string(output))
+	}
+	return nil
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		fullPath := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0o700))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0o600))
+	}
+}
+
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(fullPath string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(fullPath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fullPath)
+		files[rel] = string(content)
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestStagedRestore(t *testing.T) {
+	t.Parallel()
+
+	previous := map[string]string{"cache.db": "previous", "runc-overlayfs/metadata.db": "previous"}
+	staged := map[string]string{"cache.db": "staged", "history.db": "staged"}
+
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, stateDir string)
+		commit   bool
+		expected map[string]string
+	}{
+		{
+			name: "commit",
+			setup: func(t *testing.T, stateDir string) {
+				writeFiles(t, stateDir, previous)
+				writeFiles(t, filepath.Join(stateDir, stagingDirName), staged)
+			},
+			commit:   true,
+			expected: staged,
+		},
+		{
+			name: "rollback of incomplete extraction",
+			setup: func(t *testing.T, stateDir string) {
+				writeFiles(t, stateDir, previous)
+				writeFiles(t, filepath.Join(stateDir, stagingDirName), map[string]string{"cache.db": "partial"})
+			},
+			commit:   false,
+			expected: previous,
+		},
+		{
+			name: "rollback while backing up",
+			setup: func(t *testing.T, stateDir string) {
+				writeFiles(t, stateDir, map[string]string{"cache.db": "previous"})
+				writeFiles(t, filepath.Join(stateDir, backupDirName), map[string]string{
+					"runc-overlayfs/metadata.db": "previous",
+				})
+				writeFiles(t, filepath.Join(stateDir, stagingDirName), staged)
+			},
+			commit:   false,
+			expected: previous,
+		},
+		{
+			name: "rollback while swapping",
+			setup: func(t *testing.T, stateDir string) {
+				writeFiles(t, stateDir, map[string]string{"history.db": "staged", swappingMarkName: ""})
+				writeFiles(t, filepath.Join(stateDir, backupDirName), previous)
+				writeFiles(t, filepath.Join(stateDir, stagingDirName), map[string]string{"cache.db": "staged"})
+			},
+			commit:   false,
+			expected: previous,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			stateDir := filepath.Join(t.TempDir(), "it's buildkit")
+			tt.setup(t, stateDir)
+
+			restore := newStagedRestore(localShellDriver{}, stateDir)
+			if tt.commit {
+				require.NoError(t, restore.commit(ctx))
+			} else {
+				require.NoError(t, restore.rollback(ctx))
+			}
+			assert.Equal(t, tt.expected, readFiles(t, stateDir))
+
+			// no-op without staged state
+			require.NoError(t, restore.prepare(ctx))
+			assert.Equal(t, tt.expected, readFiles(t, stateDir))
+		})
+	}
+}
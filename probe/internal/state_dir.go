@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+)
+
+// resolveStateDir returns the state directory of buildkitd, which is given by input or detected from bkCli.
+func resolveStateDir(ctx context.Context, runner ci.CI, bkCli buildkit.Driver) (string, error) {
+	if stateDir := runner.GetInput(InputStateDir); stateDir != "" {
+		return stateDir, nil
+	}
+
+	stateDir, err := bkCli.StateDir(ctx)
+	if err != nil {
+		runner.Errorf("Failed to detect state directory of buildkitd: %+v", err)
+		return "", err
+	}
+	runner.Infof("Detected state directory of buildkitd: %s", stateDir)
+	return stateDir, nil
+}
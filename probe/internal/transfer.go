@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/pkg/errors"
+)
+
+// ExportToFile writes buildkit state into a file at path, in the same way as SaveFromContainerToRemote,
+// for transfer to machines that can not reach any remote (e.g. air-gapped build farms).
+// The file is a plain compressed tar archive, and metadata including manifest is kept in a sidecar file
+// (see exportedMetadataPath), so that ImportFromFile checks compatibility.
+func ExportToFile(ctx context.Context, runner ci.CI, nodes []buildkit.Node, path string) (err error) {
+	defer func() {
+		if err != nil {
+			runner.Errorf("Failed to export buildkit state: %+v", err)
+		}
+	}()
+
+	node, err := singleNode(nodes)
+	if err != nil {
+		return err
+	}
+	scope := newNodeScope(nodes, node)
+
+	if err = pruneUnwanted(ctx, runner, scope, node); err != nil {
+		return err
+	}
+
+	func() {
+		runner.Group(scope.title("Export buildkit state to file"))
+		defer runner.EndGroup()
+
+		var state compressedState
+		state, err = extractState(ctx, runner, node)
+		if err != nil {
+			return
+		}
+		defer state.Close()
+
+		runner.Infof("writing %s...", path)
+		err = writeExported(path, state, state.metadata)
+	}()
+	if err != nil {
+		return err
+	}
+
+	return resumeBuilder(ctx, runner, scope, node)
+}
+
+// ImportFromFile restores buildkit state from a file that ExportToFile wrote,
+// with the same compatibility and integrity checks as LoadFromRemoteToContainer.
+// Unlike loading, incompatible state fails instead of being skipped, because there is no other candidate.
+func ImportFromFile(ctx context.Context, runner ci.CI, nodes []buildkit.Node, path string) (err error) {
+	defer func() {
+		if err != nil {
+			runner.Errorf("Failed to import buildkit state: %+v", err)
+		}
+	}()
+
+	node, err := singleNode(nodes)
+	if err != nil {
+		return err
+	}
+	scope := newNodeScope(nodes, node)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	candidate := remote.NewCandidate(
+		filepath.Base(path),
+		info.ModTime(),
+		func(context.Context) (remote.LoadedCache, error) {
+			return openExported(path)
+		},
+	)
+
+	func() {
+		runner.Group(scope.title("Import buildkit state from file"))
+		defer runner.EndGroup()
+
+		var daemonInfo buildkit.DaemonInfo
+		daemonInfo, err = node.DaemonInfo(ctx)
+		if err != nil {
+			runner.Errorf("Failed to query buildkitd: %+v", err)
+			return
+		}
+
+		var stateDir string
+		stateDir, err = resolveStateDir(ctx, runner, node)
+		if err != nil {
+			return
+		}
+
+		err = restoreCandidate(ctx, runner, node, stateDir, daemonInfo, candidate)
+	}()
+	if err != nil {
+		return err
+	}
+
+	return resumeBuilder(ctx, runner, scope, node)
+}
+
+// singleNode rejects builders of multiple nodes, because a file holds the state of only one node.
+func singleNode(nodes []buildkit.Node) (buildkit.Node, error) {
+	if len(nodes) != 1 {
+		return buildkit.Node{}, errors.Errorf("builder must have exactly one node, but it has %d", len(nodes))
+	}
+	return nodes[0], nil
+}
+
+// exportedMetadataPath returns path of the sidecar file that keeps metadata of the exported file.
+func exportedMetadataPath(path string) string {
+	return path + ".json"
+}
+
+// writeExported streams data into path, and then writes its metadata into the sidecar.
+// The stale sidecar is removed first, so that new data is never paired with old metadata.
+func writeExported(path string, data io.Reader, metadata func() (remote.Metadata, error)) error {
+	metadataPath := exportedMetadataPath(path)
+	if err := os.Remove(metadataPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.WithStack(err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+
+	resolved, err := metadata()
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	encoded, err := json.Marshal(resolved)
+	if err != nil {
+		_ = os.Remove(path)
+		return errors.WithStack(err)
+	}
+	if err = writeFileAtomic(metadataPath, bytes.NewReader(encoded)); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+func openExported(path string) (remote.LoadedCache, error) {
+	// without metadata, neither compatibility nor integrity can be checked
+	raw, err := os.ReadFile(exportedMetadataPath(path))
+	if err != nil {
+		return remote.LoadedCache{}, errors.Wrapf(err, "failed to read metadata of %s", path)
+	}
+	metadata := make(remote.Metadata)
+	if err = json.Unmarshal(raw, &metadata); err != nil {
+		return remote.LoadedCache{}, errors.WithStack(err)
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return remote.LoadedCache{}, errors.WithStack(err)
+	}
+	return remote.LoadedCache{
+		Key:      filepath.Base(path),
+		Data:     fp,
+		Metadata: metadata,
+		Extra:    metadata.Extra(),
+	}, nil
+}
+
+// writeFileAtomic writes into a temporary file next to path and renames it,
+// so that interrupted export does not leave truncated file behind.
+func writeFileAtomic(path string, data io.Reader) (err error) {
+	fp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = fp.Close()
+			_ = os.Remove(fp.Name())
+		}
+	}()
+
+	if _, err = io.Copy(fp, data); err != nil {
+		return errors.WithStack(err)
+	}
+	if err = fp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(fp.Name(), path))
+}
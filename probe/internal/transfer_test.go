@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/buildkit/buildkittest"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAndImport(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		importInfo      buildkit.DaemonInfo
+		missingFile     bool
+		missingMetadata bool
+		expectedError   bool
+		expectedState   map[string]string
+		expectedStops   int
+		expectedRunning bool
+	}{
+		{
+			name:            "compatible",
+			importInfo:      testDaemonInfo,
+			expectedState:   savedState,
+			expectedStops:   1,
+			expectedRunning: true,
+		},
+		{
+			name:            "incompatible",
+			importInfo:      buildkit.DaemonInfo{Version: "v0.10.6", Snapshotter: "overlayfs", Platform: "linux/amd64"},
+			expectedError:   true,
+			expectedState:   previousState,
+			expectedStops:   0,
+			expectedRunning: true,
+		},
+		{
+			name:            "missing metadata",
+			importInfo:      testDaemonInfo,
+			missingMetadata: true,
+			expectedError:   true,
+			expectedState:   previousState,
+			expectedStops:   0,
+			expectedRunning: true,
+		},
+		{
+			name:            "missing file",
+			importInfo:      testDaemonInfo,
+			missingFile:     true,
+			expectedError:   true,
+			expectedState:   previousState,
+			expectedStops:   0,
+			expectedRunning: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "state.tar.zst")
+			if !tt.missingFile {
+				exporter := buildkittest.NewDriver(t, testDaemonInfo)
+				require.NoError(t, exporter.WriteState(savedState))
+				runner := newTestAction(t, nil, nil)
+				require.NoError(t, ExportToFile(context.Background(), runner, newTestNodes(exporter), path))
+				assert.True(t, exporter.Running)
+				assert.Equal(t, [][]string{{"exec.cachemount", "frontend"}}, exporter.Pruned)
+
+				// the file is a plain zstd compressed tar archive
+				fp, err := os.Open(path)
+				require.NoError(t, err)
+				decoder, err := zstd.NewReader(fp)
+				require.NoError(t, err)
+				_, err = tar.NewReader(decoder).Next()
+				decoder.Close()
+				require.NoError(t, fp.Close())
+				require.NoError(t, err)
+
+				raw, err := os.ReadFile(exportedMetadataPath(path))
+				require.NoError(t, err)
+				var metadata remote.Metadata
+				require.NoError(t, json.Unmarshal(raw, &metadata))
+				assert.Contains(t, metadata, remote.MetadataManifest)
+				assert.Contains(t, metadata, metadataChecksum)
+
+				if tt.missingMetadata {
+					require.NoError(t, os.Remove(exportedMetadataPath(path)))
+				}
+			}
+
+			importer := buildkittest.NewDriver(t, tt.importInfo)
+			require.NoError(t, importer.WriteState(previousState))
+			runner := newTestAction(t, nil, nil)
+			err := ImportFromFile(context.Background(), runner, newTestNodes(importer), path)
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			state, err := importer.ReadState()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedState, state)
+			assert.Equal(t, tt.expectedStops, importer.Called(buildkittest.MethodStop))
+			assert.Equal(t, tt.expectedRunning, importer.Running)
+			// import does not touch outputs nor states of loading
+			assert.Empty(t, runner.outputs(t))
+			assert.Empty(t, runner.states(t))
+		})
+	}
+}
+
+func TestExportToFile_MultipleNodes(t *testing.T) {
+	t.Parallel()
+
+	nodes := []buildkit.Node{
+		{ID: "linux-amd64", Driver: buildkittest.NewDriver(t, testDaemonInfo)},
+		{ID: "linux-arm64", Driver: buildkittest.NewDriver(t, testDaemonInfo)},
+	}
+	path := filepath.Join(t.TempDir(), "state.tar.zst")
+	assert.Error(t, ExportToFile(context.Background(), newTestAction(t, nil, nil), nodes, path))
+	assert.Error(t, ImportFromFile(context.Background(), newTestAction(t, nil, nil), nodes, path))
+	assert.NoFileExists(t, path)
+	assert.NoFileExists(t, exportedMetadataPath(path))
+}
@@ -0,0 +1,388 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/isac322/buildkit-state/probe/internal/buildkit"
+	"github.com/isac322/buildkit-state/probe/internal/buildkit/buildkittest"
+	"github.com/isac322/buildkit-state/probe/internal/ci"
+	"github.com/isac322/buildkit-state/probe/internal/remote"
+	"github.com/isac322/buildkit-state/probe/internal/remote/remotetest"
+
+	"github.com/pkg/errors"
+	"github.com/sethvargo/go-githubactions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	savedState    = map[string]string{"cache.db": "saved", "runc-overlayfs/metadata.db": "saved"}
+	previousState = map[string]string{"cache.db": "previous"}
+)
+
+// testAction runs the action with inputs and environment variables, and collects its outputs and states.
+type testAction struct {
+	ci.GitHub
+	outputFile string
+	stateFile  string
+}
+
+func newTestAction(t *testing.T, inputs, env map[string]string) testAction {
+	t.Helper()
+
+	dir := t.TempDir()
+	action := testAction{
+		outputFile: filepath.Join(dir, "output"),
+		stateFile:  filepath.Join(dir, "state"),
+	}
+
+	defaults := map[string]string{
+		InputPrimaryKey:       "key",
+		InputTargetTypes:      "exec.cachemount\nfrontend",
+		InputRewriteCache:     "false",
+		InputResumeBuilder:    "true",
+		InputCompressionLevel: "3",
+	}
+	getenv := func(name string) string {
+		switch name {
+		case "GITHUB_OUTPUT":
+			return action.outputFile
+		case "GITHUB_STATE":
+			return action.stateFile
+		}
+		if input, found := strings.CutPrefix(name, "INPUT_"); found {
+			input = strings.ToLower(input)
+			if value, found := inputs[input]; found {
+				return value
+			}
+			return defaults[input]
+		}
+		return env[name]
+	}
+	action.GitHub = ci.NewGitHub(githubactions.New(githubactions.WithWriter(io.Discard), githubactions.WithGetenv(getenv)))
+	return action
+}
+
+func (a testAction) outputs(t *testing.T) map[string]string {
+	t.Helper()
+	return readFileCommands(t, a.outputFile)
+}
+
+func (a testAction) states(t *testing.T) map[string]string {
+	t.Helper()
+	return readFileCommands(t, a.stateFile)
+}
+
+// readFileCommands parses `name<<delimiter` blocks that are written by githubactions.Action.
+func readFileCommands(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	values := make(map[string]string)
+	fp, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return values
+	}
+	require.NoError(t, err)
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		name, delimiter, found := strings.Cut(scanner.Text(), "<<")
+		require.True(t, found)
+		var lines []string
+		for scanner.Scan() && scanner.Text() != delimiter {
+			lines = append(lines, scanner.Text())
+		}
+		values[name] = strings.Join(lines, "\n")
+	}
+	require.NoError(t, scanner.Err())
+	return values
+}
+
+func newTestNodes(driver buildkit.Driver) []buildkit.Node {
+	return []buildkit.Node{{ID: "node0", Driver: driver}}
+}
+
+// saveTestState saves files as buildkit state into manager under key.
+func saveTestState(t *testing.T, manager remote.Manager, key string, files map[string]string) {
+	t.Helper()
+
+	driver := buildkittest.NewDriver(t, testDaemonInfo)
+	require.NoError(t, driver.WriteState(files))
+	runner := newTestAction(t, map[string]string{InputPrimaryKey: key}, nil)
+	require.NoError(t, SaveFromContainerToRemote(context.Background(), runner, newTestNodes(driver), manager))
+}
+
+type failingSaveManager struct {
+	remote.Manager
+}
+
+func (failingSaveManager) Save(context.Context, string, io.Reader, int64, remote.Metadata) error {
+	return errors.New("quota exceeded")
+}
+
+func TestLoadFromRemoteToContainer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		savedKeys       []string
+		inputs          map[string]string
+		env             map[string]string
+		driverErrors    map[string]error
+		expectedError   bool
+		expectedState   map[string]string
+		expectedOutputs map[string]string
+		expectedStates  map[string]string
+		expectedRunning bool
+		expectedStops   int
+	}{
+		{
+			name:            "cache hit",
+			savedKeys:       []string{"key"},
+			expectedState:   savedState,
+			expectedOutputs: map[string]string{outputRestoredCacheKey: "key"},
+			expectedStates:  map[string]string{stateLoadedCacheKey: "key"},
+			expectedRunning: true,
+			expectedStops:   1,
+		},
+		{
+			name:            "restore key hit",
+			savedKeys:       []string{"key-old"},
+			inputs:          map[string]string{InputPrimaryKey: "key-new", InputSecondaryKeys: "key-"},
+			expectedState:   savedState,
+			expectedOutputs: map[string]string{outputRestoredCacheKey: "key-old"},
+			expectedStates:  map[string]string{stateLoadedCacheKey: "key-old"},
+			expectedRunning: true,
+			expectedStops:   1,
+		},
+		{
+			name:            "cache miss",
+			savedKeys:       []string{"other"},
+			expectedState:   previousState,
+			expectedOutputs: map[string]string{},
+			expectedStates:  map[string]string{},
+			expectedRunning: true,
+			expectedStops:   0,
+		},
+		{
+			name:            "cache miss resets stale state",
+			savedKeys:       []string{"other"},
+			env:             map[string]string{"STATE_" + stateLoadedCacheKey: "key"},
+			expectedState:   previousState,
+			expectedOutputs: map[string]string{},
+			expectedStates:  map[string]string{stateLoadedCacheKey: ""},
+			expectedRunning: true,
+			expectedStops:   0,
+		},
+		{
+			name:            "without resuming builder",
+			savedKeys:       []string{"key"},
+			inputs:          map[string]string{InputResumeBuilder: "false"},
+			expectedState:   savedState,
+			expectedOutputs: map[string]string{outputRestoredCacheKey: "key"},
+			expectedStates:  map[string]string{stateLoadedCacheKey: "key"},
+			expectedRunning: false,
+			expectedStops:   1,
+		},
+		{
+			name:            "failure of querying buildkitd",
+			savedKeys:       []string{"key"},
+			driverErrors:    map[string]error{buildkittest.MethodDaemonInfo: errors.New("connection refused")},
+			expectedError:   true,
+			expectedState:   previousState,
+			expectedOutputs: map[string]string{},
+			expectedStates:  map[string]string{},
+			expectedRunning: true,
+			expectedStops:   0,
+		},
+		{
+			name:            "rollback of failed extraction",
+			savedKeys:       []string{"key"},
+			driverErrors:    map[string]error{buildkittest.MethodCopyTo: errors.New("disk is full")},
+			expectedError:   true,
+			expectedState:   previousState,
+			expectedOutputs: map[string]string{},
+			expectedStates:  map[string]string{},
+			expectedRunning: true,
+			expectedStops:   1,
+		},
+		{
+			name:            "failure of stopping buildkitd",
+			savedKeys:       []string{"key"},
+			driverErrors:    map[string]error{buildkittest.MethodStop: errors.New("permission denied")},
+			expectedError:   true,
+			expectedState:   previousState,
+			expectedOutputs: map[string]string{},
+			expectedStates:  map[string]string{},
+			expectedRunning: true,
+			expectedStops:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			manager := remotetest.NewMemoryManager()
+			for _, key := range tt.savedKeys {
+				saveTestState(t, manager, key, savedState)
+			}
+
+			driver := buildkittest.NewDriver(t, testDaemonInfo)
+			require.NoError(t, driver.WriteState(previousState))
+			for method, err := range tt.driverErrors {
+				driver.Errors[method] = err
+			}
+			runner := newTestAction(t, tt.inputs, tt.env)
+
+			err := LoadFromRemoteToContainer(context.Background(), runner, newTestNodes(driver), manager)
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			state, err := driver.ReadState()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedState, state)
+			assert.Equal(t, tt.expectedOutputs, runner.outputs(t))
+			assert.Equal(t, tt.expectedStates, runner.states(t))
+			assert.Equal(t, tt.expectedRunning, driver.Running)
+			assert.Equal(t, tt.expectedStops, driver.Called(buildkittest.MethodStop))
+		})
+	}
+}
+
+func TestSaveFromContainerToRemote(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		inputs        map[string]string
+		env           map[string]string
+		driverErrors  map[string]error
+		failSave      bool
+		expectedError bool
+		expectedKeys  []string
+		expectedPrune [][]string
+		expectedStops int
+	}{
+		{
+			name:          "new cache",
+			expectedKeys:  []string{"key"},
+			expectedPrune: [][]string{{"exec.cachemount", "frontend"}},
+			expectedStops: 1,
+		},
+		{
+			name:          "skip restored cache",
+			env:           map[string]string{"STATE_" + stateLoadedCacheKey: "key"},
+			expectedKeys:  nil,
+			expectedPrune: nil,
+			expectedStops: 0,
+		},
+		{
+			name:          "rewrite restored cache",
+			inputs:        map[string]string{InputRewriteCache: "true"},
+			env:           map[string]string{"STATE_" + stateLoadedCacheKey: "key"},
+			expectedKeys:  []string{"key"},
+			expectedPrune: [][]string{{"exec.cachemount", "frontend"}},
+			expectedStops: 1,
+		},
+		{
+			name:          "save restored from other key",
+			env:           map[string]string{"STATE_" + stateLoadedCacheKey: "key-old"},
+			expectedKeys:  []string{"key"},
+			expectedPrune: [][]string{{"exec.cachemount", "frontend"}},
+			expectedStops: 1,
+		},
+		{
+			name:          "failure of pruning",
+			driverErrors:  map[string]error{buildkittest.MethodPruneExcept: errors.New("connection refused")},
+			expectedError: true,
+			expectedKeys:  nil,
+			expectedPrune: nil,
+			expectedStops: 0,
+		},
+		{
+			name:          "invalid compression level",
+			inputs:        map[string]string{InputCompressionLevel: "fast"},
+			expectedError: true,
+			expectedKeys:  nil,
+			expectedPrune: [][]string{{"exec.cachemount", "frontend"}},
+			expectedStops: 0,
+		},
+		{
+			name:          "compression level out of range",
+			inputs:        map[string]string{InputCompression: "gzip", InputCompressionLevel: "19"},
+			expectedError: true,
+			expectedKeys:  nil,
+			expectedPrune: [][]string{{"exec.cachemount", "frontend"}},
+			expectedStops: 0,
+		},
+		{
+			name:          "failure of uploading",
+			failSave:      true,
+			expectedError: true,
+			expectedKeys:  nil,
+			expectedPrune: [][]string{{"exec.cachemount", "frontend"}},
+			expectedStops: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			memory := remotetest.NewMemoryManager()
+			var manager remote.Manager = memory
+			if tt.failSave {
+				manager = failingSaveManager{memory}
+			}
+
+			driver := buildkittest.NewDriver(t, testDaemonInfo)
+			require.NoError(t, driver.WriteState(savedState))
+			for method, err := range tt.driverErrors {
+				driver.Errors[method] = err
+			}
+			runner := newTestAction(t, tt.inputs, tt.env)
+
+			err := SaveFromContainerToRemote(context.Background(), runner, newTestNodes(driver), manager)
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.ElementsMatch(t, tt.expectedKeys, memory.Keys())
+			assert.Equal(t, tt.expectedPrune, driver.Pruned)
+			assert.Equal(t, tt.expectedStops, driver.Called(buildkittest.MethodStop))
+			// state of buildkitd is not changed by saving
+			state, err := driver.ReadState()
+			require.NoError(t, err)
+			assert.Equal(t, savedState, state)
+
+			if len(tt.expectedKeys) == 0 {
+				return
+			}
+			candidates, err := manager.Load(context.Background(), "key", nil)
+			require.NoError(t, err)
+			require.Len(t, candidates, 1)
+			cache, err := candidates[0].Open(context.Background())
+			require.NoError(t, err)
+			defer cache.Data.Close()
+			manifest, found := cache.Manifest().Get()
+			require.True(t, found)
+			assert.Equal(t, testDaemonInfo.Version, manifest.BuildkitVersion)
+			assert.Equal(t, []string{"exec.cachemount", "frontend"}, manifest.TargetTypes)
+		})
+	}
+}